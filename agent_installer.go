@@ -1,9 +1,13 @@
 package sealights
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"math"
 	"mime"
 	"net/http"
@@ -30,59 +34,138 @@ const VersionFileName = "version.txt"
 
 const AgentDownloadUrlFormat = "https://agents.sealights.co/dotnetcore/%s/%s"
 
+const ExecutableFileMode = 0755
+const DataFileMode = 0644
+
+var sharedLibraryExtensions = []string{".so", ".dll", ".dylib", ".exe"}
+
+var executableMagicNumbers = [][]byte{
+	[]byte("\x7fELF"), // linux binaries
+	[]byte("MZ"),      // windows binaries
+	[]byte("#!"),      // scripts
+}
+
 type AgentInstaller struct {
 	Log                *libbuildpack.Logger
 	Options            *SealightsOptions
 	MaxDownloadRetries int
+	MaxArchiveSize     int64
+	MaxArchiveEntries  int
+
+	// external actions, replaced by fakes in the unit tests
+	Command    Command
+	HTTPClient HTTPClient
+	FileSystem FileSystem
+
+	// details of the installed package, filled by InstallAgent
+	PackageUrl    string
+	PackageSha256 string
 }
 
 func NewAgentInstaller(log *libbuildpack.Logger, options *SealightsOptions) *AgentInstaller {
-	return &AgentInstaller{Log: log, Options: options, MaxDownloadRetries: 3}
+	agentInstaller := &AgentInstaller{
+		Log:                log,
+		Options:            options,
+		MaxDownloadRetries: 3,
+		MaxArchiveSize:     DefaultMaxArchiveSize,
+		MaxArchiveEntries:  DefaultMaxArchiveEntries,
+		Command:            &libbuildpack.Command{},
+		FileSystem:         OsFileSystem{},
+	}
+	agentInstaller.HTTPClient = agentInstaller.createClient()
+
+	return agentInstaller
 }
 
 func (agi *AgentInstaller) InstallAgent(stager *libbuildpack.Stager) (string, string, error) {
 	installationPath := filepath.Join(stager.BuildDir(), AgentDir)
-	archivePath, err := agi.downloadPackage()
+
+	agentVersion, err := agi.InstallAgentToDir(installationPath)
 	if err != nil {
 		return "", "", err
 	}
 
+	return AgentDir, agentVersion, nil
+}
+
+// InstallAgentToDir downloads the agent package and extracts it into the
+// installation path. Returns the installed agent version
+func (agi *AgentInstaller) InstallAgentToDir(installationPath string) (string, error) {
+	url, _, err := agi.ResolvePackage()
+	if err != nil {
+		agi.Log.Error("Sealights. Failed to resolve package url.")
+		return "", err
+	}
+
+	return agi.InstallPackageToDir(url, installationPath)
+}
+
+// InstallPackageToDir installs the package already resolved by ResolvePackage,
+// so the feed isn't queried again and the same version is installed
+func (agi *AgentInstaller) InstallPackageToDir(url string, installationPath string) (string, error) {
+	// unique directory per staging, so concurrent stagings on the same host don't collide
+	tempDir, err := agi.FileSystem.MkdirTemp("", "sealights-")
+	if err != nil {
+		return "", err
+	}
+	defer agi.removeTempDir(tempDir)
+
+	archivePath, err := agi.downloadPackage(url, tempDir)
+	if err != nil {
+		return "", err
+	}
+
+	agi.PackageSha256, err = agi.fileSha256(archivePath)
+	if err != nil {
+		return "", err
+	}
+
 	err = agi.extractPackage(archivePath, installationPath)
 	if err != nil {
-		return "", "", err
+		return "", err
 	}
 
-	agentVersion := agi.readAgentVersion(installationPath)
+	err = validateInstrumentationMode(agi.FileSystem, agi.Options.InstrumentationMode, installationPath)
+	if err != nil {
+		return "", err
+	}
 
-	return AgentDir, agentVersion, nil
+	return agi.readAgentVersion(installationPath), nil
 }
 
-func (agi *AgentInstaller) downloadPackage() (string, error) {
-	url := agi.getDownloadUrl()
+func (agi *AgentInstaller) removeTempDir(tempDir string) {
+	if err := agi.FileSystem.RemoveAll(tempDir); err != nil {
+		agi.Log.Warning("Sealights. Failed to remove temporary directory '%s': %v", tempDir, err)
+	}
+}
 
+func (agi *AgentInstaller) downloadPackage(url string, tempDir string) (string, error) {
 	agi.Log.Debug("Sealights. Download package started. From '%s'", url)
+	agi.PackageUrl = url
 
-	tempAgentFile, err := agi.downloadFileWithRetry(url, agi.MaxDownloadRetries)
+	startTime := time.Now()
+	tempAgentFile, err := agi.downloadFileWithRetry(url, tempDir, agi.MaxDownloadRetries)
 	if err != nil {
 		agi.Log.Error("Sealights. Failed to download package.")
 		return "", err
 	}
 
-	agi.Log.Debug("Sealights. Download finished.")
+	downloadSize := int64(-1)
+	if info, err := agi.FileSystem.Stat(tempAgentFile); err == nil {
+		downloadSize = info.Size()
+	}
+
+	agi.Log.Info("Sealights. Package downloaded (%d bytes in %s)", downloadSize, time.Since(startTime).Round(time.Millisecond))
 	return tempAgentFile, nil
 }
 
 func (agi *AgentInstaller) extractPackage(source string, target string) error {
 	agi.Log.Debug("Sealights. Extract package from '%s' to '%s'", source, target)
 
-	var err error
-	var isZip = strings.HasSuffix(source, ".zip") || strings.HasSuffix(source, ".nupkg")
-	if isZip {
-		err = libbuildpack.ExtractZip(source, target)
-	} else {
-		err = libbuildpack.ExtractTarGz(source, target)
-	}
-
+	extractor := NewArchiveExtractor(agi.MaxArchiveSize, agi.MaxArchiveEntries)
+	extractor.Command = agi.Command
+	extractor.FileSystem = agi.FileSystem
+	err := extractor.Extract(source, target)
 	if err != nil {
 		agi.Log.Error("Sealights. Failed to extract package.")
 		return err
@@ -94,13 +177,19 @@ func (agi *AgentInstaller) extractPackage(source string, target string) error {
 		return err
 	}
 
+	err = agi.updateFilePermissions(target)
+	if err != nil {
+		agi.Log.Error("Sealights. Failed to update file permissions")
+		return err
+	}
+
 	agi.Log.Debug("Sealights. Package extracted.")
 	return nil
 }
 
 func (agi *AgentInstaller) extractContentIfNeeded(target string) error {
 	contentDirectory := filepath.Join(target, "content")
-	found, err := libbuildpack.FileExists(contentDirectory)
+	found, err := fileExists(agi.FileSystem, contentDirectory)
 	if err != nil {
 		return err
 	} else if found {
@@ -108,7 +197,7 @@ func (agi *AgentInstaller) extractContentIfNeeded(target string) error {
 		// regular installation package. need to extract corresponding
 		// agent from the content to align them
 
-		singlePackage, err := libbuildpack.FileExists(filepath.Join(contentDirectory, "version.txt"))
+		singlePackage, err := fileExists(agi.FileSystem, filepath.Join(contentDirectory, "version.txt"))
 		if err != nil {
 			return err
 		}
@@ -118,23 +207,34 @@ func (agi *AgentInstaller) extractContentIfNeeded(target string) error {
 			agentDir = filepath.Join(contentDirectory, getPackageDirByPlatform())
 		}
 
-		err = libbuildpack.MoveDirectory(agentDir, target)
+		err = moveDirectory(agi.FileSystem, agentDir, target)
 		if err != nil {
 			return err
 		}
-
-		agi.updateFilePermissions(target)
 	}
 
 	// remove "content" directory once it not needed
-	os.RemoveAll(contentDirectory)
+	agi.FileSystem.RemoveAll(contentDirectory)
 
 	return nil
 }
 
-func (agi *AgentInstaller) getDownloadUrl() string {
+// ResolvePackage returns download url and the requested version of the agent package.
+// Nothing is downloaded, only the nuget feed metadata is requested if the feed is used
+func (agi *AgentInstaller) ResolvePackage() (string, string, error) {
 	if agi.Options.CustomAgentUrl != "" {
-		return agi.Options.CustomAgentUrl
+		return agi.Options.CustomAgentUrl, "custom", nil
+	}
+
+	if agi.Options.NugetFeed != "" {
+		feed := NewNugetFeed(agi.Log, agi.HTTPClient, agi.Options)
+		packageUrl, packageVersion, err := feed.ResolvePackageUrl(agi.Options.NugetPackageId, agi.Options.Version)
+		if err != nil {
+			return "", "", err
+		}
+
+		agi.Log.Debug("Sealights. Package '%s' version '%s' resolved from the nuget feed", agi.Options.NugetPackageId, packageVersion)
+		return packageUrl, packageVersion, nil
 	}
 
 	version := DefaultVersion
@@ -148,16 +248,16 @@ func (agi *AgentInstaller) getDownloadUrl() string {
 	// https://agents.sealights.co/dotnetcore/latest/sealights-dotnet-agent-linux-self-contained.tar.gz
 	url := fmt.Sprintf(AgentDownloadUrlFormat, version, packageName)
 
-	return url
+	return url, version, nil
 }
 
-func (agi *AgentInstaller) downloadFileWithRetry(url string, MaxDownloadRetries int) (string, error) {
+func (agi *AgentInstaller) downloadFileWithRetry(url string, destDir string, MaxDownloadRetries int) (string, error) {
 	const baseWaitTime = 3 * time.Second
 
 	var err error
 	var filePath string
 	for i := 0; i < MaxDownloadRetries; i++ {
-		filePath, err = agi.downloadFile(url)
+		filePath, err = agi.downloadFile(url, destDir)
 		if err == nil {
 			return filePath, nil
 		}
@@ -169,10 +269,19 @@ func (agi *AgentInstaller) downloadFileWithRetry(url string, MaxDownloadRetries
 	return "", err
 }
 
-func (agi *AgentInstaller) downloadFile(agentUrl string) (string, error) {
-	client := agi.createClient()
+func (agi *AgentInstaller) downloadFile(agentUrl string, destDir string) (string, error) {
+	client := agi.HTTPClient
 
-	resp, err := client.Get(agentUrl)
+	request, err := http.NewRequest(http.MethodGet, agentUrl, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if agi.Options.NugetFeed != "" {
+		NewNugetFeed(agi.Log, client, agi.Options).Authorize(request)
+	}
+
+	resp, err := client.Do(request)
 	if err != nil {
 		return "", err
 	}
@@ -187,13 +296,13 @@ func (agi *AgentInstaller) downloadFile(agentUrl string) (string, error) {
 		fileName = getPackageNameByPlatform()
 	}
 
-	destFile := filepath.Join(os.TempDir(), fileName)
+	destFile := filepath.Join(destDir, fileName)
 
-	return destFile, writeToFile(resp.Body, destFile, 0666)
+	return destFile, writeFile(agi.FileSystem, resp.Body, destFile, 0666)
 }
 
 // Create simple client or client with proxy, based on the settings
-func (agi *AgentInstaller) createClient() *http.Client {
+func (agi *AgentInstaller) createClient() HTTPClient {
 	if agi.Options.Proxy != "" {
 		proxyUrl, _ := url.Parse(agi.Options.Proxy)
 
@@ -211,21 +320,44 @@ func (agi *AgentInstaller) createClient() *http.Client {
 	}
 }
 
+// Walk through the installation directory and normalize permissions:
+// directories, executables and shared libraries get 0755, the rest 0644.
+// Archives don't always preserve modes (e.g. zip and nuget packages), so
+// nested native libraries and the apphost may otherwise lack exec bits
 func (agi *AgentInstaller) updateFilePermissions(installationPath string) error {
-	files, err := os.ReadDir(installationPath)
-	if err != nil {
-		return err
-	}
+	return agi.FileSystem.WalkDir(installationPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
 
-	for _, file := range files {
-		os.Chmod(filepath.Join(installationPath, file.Name()), 0755)
-	}
+		if entry.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
 
-	return nil
+		mode := os.FileMode(DataFileMode)
+		if entry.IsDir() {
+			mode = ExecutableFileMode
+		} else {
+			executable, err := agi.isExecutableFile(filePath)
+			if err != nil {
+				return err
+			}
+
+			if executable {
+				mode = ExecutableFileMode
+			}
+		}
+
+		if err := agi.FileSystem.Chmod(filePath, mode); err != nil {
+			return fmt.Errorf("failed to change permissions of '%s': %w", filePath, err)
+		}
+
+		return nil
+	})
 }
 
 func (agi *AgentInstaller) readAgentVersion(installationPath string) string {
-	data, err := os.ReadFile(filepath.Join(installationPath, VersionFileName))
+	data, err := agi.FileSystem.ReadFile(filepath.Join(installationPath, VersionFileName))
 	if err != nil {
 		agi.Log.Warning("Failed to get agent version: %v", err)
 		return "unknown"
@@ -236,24 +368,55 @@ func (agi *AgentInstaller) readAgentVersion(installationPath string) string {
 	return strings.TrimSuffix(agentVersion, "\n")
 }
 
-func writeToFile(source io.Reader, destFile string, mode os.FileMode) error {
-	err := os.MkdirAll(filepath.Dir(destFile), 0755)
+func (agi *AgentInstaller) fileSha256(filePath string) (string, error) {
+	fh, err := agi.FileSystem.Open(filePath)
 	if err != nil {
-		return err
+		return "", err
+	}
+	defer fh.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, fh); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func writeToFile(source io.Reader, destFile string, mode os.FileMode) error {
+	return writeFile(OsFileSystem{}, source, destFile, mode)
+}
+
+// Check if file should be executable: native libraries by extension,
+// binaries and scripts by their magic bytes
+func (agi *AgentInstaller) isExecutableFile(filePath string) (bool, error) {
+	extension := strings.ToLower(filepath.Ext(filePath))
+	for _, libraryExtension := range sharedLibraryExtensions {
+		if extension == libraryExtension {
+			return true, nil
+		}
 	}
 
-	fh, err := os.OpenFile(destFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+	fh, err := agi.FileSystem.Open(filePath)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer fh.Close()
 
-	_, err = io.Copy(fh, source)
-	if err != nil {
-		return err
+	header := make([]byte, 4)
+	n, err := io.ReadFull(fh, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
 	}
 
-	return nil
+	header = header[:n]
+	for _, magic := range executableMagicNumbers {
+		if bytes.HasPrefix(header, magic) {
+			return true, nil
+		}
+	}
+
+	return false, nil
 }
 
 func getPackageNameByPlatform() string {
@@ -0,0 +1,93 @@
+package sealights
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+func TestUpdateFilePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not supported on windows")
+	}
+
+	installationPath := t.TempDir()
+	files := map[string]struct {
+		content  string
+		expected os.FileMode
+	}{
+		"SL.DotNet":                     {"\x7fELF....", ExecutableFileMode},
+		"scripts/start.sh":              {"#!/bin/sh\n", ExecutableFileMode},
+		"runtimes/linux-x64/native.so":  {"library", ExecutableFileMode},
+		"SL.DotNet.dll":                 {"assembly", ExecutableFileMode},
+		"appsettings.json":              {"{}", DataFileMode},
+		"runtimes/linux-x64/readme.txt": {"", DataFileMode},
+	}
+	for name, file := range files {
+		filePath := filepath.Join(installationPath, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(file.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	agentInstaller := &AgentInstaller{FileSystem: OsFileSystem{}}
+	if err := agentInstaller.updateFilePermissions(installationPath); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, file := range files {
+		info, err := os.Stat(filepath.Join(installationPath, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != file.expected {
+			t.Errorf("'%s': expected mode %o, got %o", name, file.expected, info.Mode().Perm())
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(installationPath, "runtimes", "linux-x64"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != ExecutableFileMode {
+		t.Errorf("nested directory: expected mode %o, got %o", ExecutableFileMode, info.Mode().Perm())
+	}
+}
+
+func TestDownloadPackageIntoTempDir(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", "attachment; filename=agent.tar.gz")
+		w.Write([]byte("package"))
+	}))
+	defer server.Close()
+
+	log := libbuildpack.NewLogger(&bytes.Buffer{})
+	agentInstaller := NewAgentInstaller(log, &SealightsOptions{CustomAgentUrl: server.URL})
+
+	tempDir := filepath.Join(t.TempDir(), "sealights-1")
+	if err := os.Mkdir(tempDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath, err := agentInstaller.downloadPackage(server.URL, tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archivePath != filepath.Join(tempDir, "agent.tar.gz") {
+		t.Errorf("package is expected in the staging temp dir, got '%s'", archivePath)
+	}
+
+	agentInstaller.removeTempDir(tempDir)
+	if _, err := os.Stat(tempDir); !os.IsNotExist(err) {
+		t.Error("temp dir is expected to be removed")
+	}
+}
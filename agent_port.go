@@ -0,0 +1,58 @@
+package sealights
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// AgentPortAuto selects a free port when the container starts, it's done by the supervisor
+const AgentPortAuto = "auto"
+
+// AgentPortArgument is the agent cli option the port is passed with
+const AgentPortArgument = "port"
+
+const AgentPortEnvVariable = "SL_AGENT_PORT"
+
+// ParseAgentPort accepts empty value (default port), 'auto' or the port number
+func ParseAgentPort(value interface{}) (string, error) {
+	var port string
+	switch typed := value.(type) {
+	case nil:
+		return DefaultPort, nil
+	case string:
+		port = strings.TrimSpace(typed)
+	case float64:
+		port = strconv.FormatFloat(typed, 'f', -1, 64)
+	default:
+		return DefaultPort, fmt.Errorf("unexpected value '%v'", value)
+	}
+
+	if port == "" {
+		return DefaultPort, nil
+	}
+
+	if strings.EqualFold(port, AgentPortAuto) {
+		return AgentPortAuto, nil
+	}
+
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 1 || number > 65535 {
+		return DefaultPort, fmt.Errorf("port should be a number in range 1-65535 or '%s', got '%s'", AgentPortAuto, port)
+	}
+
+	return strconv.Itoa(number), nil
+}
+
+// Ask the system for the free port. There is a small window until the agent binds it,
+// but the container network namespace is used only by the application processes
+func pickFreePort() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer listener.Close()
+
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port), nil
+}
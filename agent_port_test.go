@@ -0,0 +1,41 @@
+package sealights
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestParseAgentPort(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, DefaultPort},
+		{"", DefaultPort},
+		{" 32000 ", "32000"},
+		{float64(32001), "32001"},
+		{"AUTO", AgentPortAuto},
+	}
+	for _, test := range tests {
+		port, err := ParseAgentPort(test.value)
+		if err != nil || port != test.expected {
+			t.Errorf("'%v': expected '%s', got '%s' (%v)", test.value, test.expected, port, err)
+		}
+	}
+
+	for _, value := range []interface{}{"0", "65536", "port", true} {
+		if port, err := ParseAgentPort(value); err == nil || port != DefaultPort {
+			t.Errorf("'%v': expected the default port with an error, got '%s' (%v)", value, port, err)
+		}
+	}
+}
+
+func TestPickFreePort(t *testing.T) {
+	port, err := pickFreePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+		t.Errorf("unexpected port '%s'", port)
+	}
+}
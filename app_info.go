@@ -0,0 +1,327 @@
+package sealights
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type AppKind string
+
+const (
+	AppKindUnknown             AppKind = "unknown"
+	AppKindFrameworkDependent  AppKind = "framework-dependent"
+	AppKindSelfContained       AppKind = "self-contained"
+	AppKindSingleFile          AppKind = "single-file"
+	AppKindNativeAot           AppKind = "native-aot"
+	AppKindNetFramework        AppKind = "net-framework"
+	startupHookSupportProperty         = "System.StartupHookProvider.IsSupported"
+)
+
+// signature placed by the sdk into the apphost of the single-file bundle
+var bundleSignature = []byte{
+	0x8b, 0x12, 0x02, 0xb9, 0x6a, 0x61, 0x20, 0x38,
+	0x72, 0x7b, 0x93, 0x02, 0x14, 0xd7, 0xa0, 0x32,
+	0x13, 0xf5, 0xb9, 0xe6, 0xef, 0xae, 0x33, 0x18,
+	0xee, 0x3b, 0x2d, 0xce, 0x24, 0xb3, 0x6a, 0xae,
+}
+
+// AppInfo describes how the .NET application is published
+type AppInfo struct {
+	Kind                  AppKind  `json:"kind"`
+	PublishDir            string   `json:"publishDir"`
+	Name                  string   `json:"name,omitempty"`
+	Frameworks            []string `json:"frameworks,omitempty"`
+	RuntimeIdentifier     string   `json:"runtimeIdentifier,omitempty"`
+	StartupHooksSupported bool     `json:"startupHooksSupported"`
+}
+
+type runtimeConfigModel struct {
+	RuntimeOptions struct {
+		Framework          *frameworkReference    `json:"framework"`
+		Frameworks         []frameworkReference   `json:"frameworks"`
+		IncludedFrameworks []frameworkReference   `json:"includedFrameworks"`
+		ConfigProperties   map[string]interface{} `json:"configProperties"`
+	} `json:"runtimeOptions"`
+}
+
+type depsModel struct {
+	RuntimeTarget struct {
+		Name string `json:"name"`
+	} `json:"runtimeTarget"`
+}
+
+type frameworkReference struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// DetectApplication inspects the publish directory. Framework-dependent and self-contained
+// applications are recognized by *.runtimeconfig.json, single-file bundles and Native AOT
+// executables have no runtimeconfig next to them and differ by the bundle signature.
+// IIS hosted .NET Framework applications are recognized by Web.config
+func DetectApplication(fileSystem FileSystem, publishDir string, name string) (*AppInfo, error) {
+	app := &AppInfo{Kind: AppKindUnknown, PublishDir: publishDir, Name: name, StartupHooksSupported: true}
+
+	isHwc, err := isHwcApplication(fileSystem, publishDir)
+	if err != nil {
+		return nil, err
+	}
+	if isHwc {
+		app.Kind = AppKindNetFramework
+		app.Name = filepath.Base(publishDir)
+		app.StartupHooksSupported = false
+		return app, nil
+	}
+
+	if app.Name == "" {
+		matches, err := findRuntimeConfigs(fileSystem, publishDir)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) != 1 {
+			return app, nil
+		}
+		app.Name = strings.TrimSuffix(filepath.Base(matches[0]), ".runtimeconfig.json")
+	}
+
+	if err := app.readDeps(fileSystem); err != nil {
+		return nil, err
+	}
+
+	found, err := app.readRuntimeConfig(fileSystem)
+	if err != nil || found {
+		return app, err
+	}
+
+	executable := filepath.Join(publishDir, app.Name)
+	if _, err := fileSystem.Stat(executable); err != nil {
+		if os.IsNotExist(err) {
+			return app, nil
+		}
+		return nil, err
+	}
+
+	isBundle, err := fileContains(fileSystem, executable, bundleSignature)
+	if err != nil {
+		return nil, err
+	}
+
+	if isBundle {
+		app.Kind = AppKindSingleFile
+
+		// runtimeconfig is embedded into the bundle, trimmed applications have startup hooks disabled in it
+		hooksDisabled, err := fileContains(fileSystem, executable, []byte(`"`+startupHookSupportProperty+`": false`))
+		if err != nil {
+			return nil, err
+		}
+		app.StartupHooksSupported = !hooksDisabled
+
+		return app, nil
+	}
+
+	managedAssemblyFound, err := fileExists(fileSystem, filepath.Join(publishDir, app.Name+".dll"))
+	if err != nil {
+		return nil, err
+	}
+	if !managedAssemblyFound {
+		app.Kind = AppKindNativeAot
+		app.StartupHooksSupported = false
+	}
+
+	return app, nil
+}
+
+func (app *AppInfo) readRuntimeConfig(fileSystem FileSystem) (bool, error) {
+	data, err := fileSystem.ReadFile(filepath.Join(app.PublishDir, app.Name+".runtimeconfig.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	var runtimeConfig runtimeConfigModel
+	if err = json.Unmarshal(data, &runtimeConfig); err != nil {
+		return false, err
+	}
+
+	options := runtimeConfig.RuntimeOptions
+	if len(options.IncludedFrameworks) > 0 {
+		app.Kind = AppKindSelfContained
+		app.Frameworks = frameworkNames(options.IncludedFrameworks)
+	} else {
+		app.Kind = AppKindFrameworkDependent
+		references := options.Frameworks
+		if options.Framework != nil {
+			references = append(references, *options.Framework)
+		}
+		app.Frameworks = frameworkNames(references)
+	}
+
+	if supported, ok := options.ConfigProperties[startupHookSupportProperty].(bool); ok {
+		app.StartupHooksSupported = supported
+	}
+
+	return true, nil
+}
+
+// Runtime identifier is a part of the target name for the runtime specific publishing:
+// ".NETCoreApp,Version=v8.0/linux-x64"
+func (app *AppInfo) readDeps(fileSystem FileSystem) error {
+	data, err := fileSystem.ReadFile(filepath.Join(app.PublishDir, app.Name+".deps.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var deps depsModel
+	if err = json.Unmarshal(data, &deps); err != nil {
+		return err
+	}
+
+	parts := strings.SplitN(deps.RuntimeTarget.Name, "/", 2)
+	if len(parts) == 2 {
+		app.RuntimeIdentifier = parts[1]
+	}
+
+	return nil
+}
+
+// Full framework web application has Web.config in the root and no runtimeconfig,
+// asp.net core applications published for IIS have both of them
+func isHwcApplication(fileSystem FileSystem, dir string) (bool, error) {
+	runtimeConfigs, err := findRuntimeConfigs(fileSystem, dir)
+	if err != nil || len(runtimeConfigs) > 0 {
+		return false, err
+	}
+
+	for _, name := range []string{"Web.config", "web.config"} {
+		exists, err := fileExists(fileSystem, filepath.Join(dir, name))
+		if err != nil || exists {
+			return exists, err
+		}
+	}
+
+	return false, nil
+}
+
+// Get *.runtimeconfig.json files of the directory, missing directory has none of them
+func findRuntimeConfigs(fileSystem FileSystem, dir string) ([]string, error) {
+	entries, err := fileSystem.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var matches []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".runtimeconfig.json") {
+			matches = append(matches, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	return matches, nil
+}
+
+// IsInstrumentable returns false for applications without the CoreCLR runtime
+func (app *AppInfo) IsInstrumentable() bool {
+	return app.Kind != AppKindNativeAot
+}
+
+// IsSupportedRuntime returns false if the profiler library isn't built for the runtime of the application
+func (app *AppInfo) IsSupportedRuntime() bool {
+	rid := strings.ToLower(app.RuntimeIdentifier)
+	return !strings.Contains(rid, "arm") && !strings.Contains(rid, "musl")
+}
+
+// Get the name of the entry point from the start command:
+// exec ./app --server.urls ...      -> app
+// exec dotnet ./app.dll --server... -> app
+func applicationNameFromCommand(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) > 0 && fields[0] == "exec" {
+		fields = fields[1:]
+	}
+
+	if len(fields) == 0 {
+		return ""
+	}
+
+	entry := fields[0]
+	if filepath.Base(entry) == "dotnet" {
+		if len(fields) < 2 {
+			return ""
+		}
+		entry = fields[1]
+	}
+
+	return strings.TrimSuffix(filepath.Base(entry), ".dll")
+}
+
+// Get publish directory from the 'cd <dir> && ...' prefix of the start command
+func publishDirFromCommand(command string, buildDir string, depsDir string) string {
+	if !strings.HasPrefix(command, "cd ") {
+		return buildDir
+	}
+
+	dir := strings.TrimSpace(strings.SplitN(strings.TrimPrefix(command, "cd "), "&&", 2)[0])
+	dir = strings.NewReplacer("${DEPS_DIR}", depsDir, "$DEPS_DIR", depsDir, "${HOME}", buildDir, "$HOME", buildDir).Replace(dir)
+
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(buildDir, dir)
+	}
+
+	return dir
+}
+
+func frameworkNames(references []frameworkReference) []string {
+	var names []string
+	for _, reference := range references {
+		names = append(names, reference.Name)
+	}
+
+	return names
+}
+
+// Search the pattern in the file without loading it into memory
+func fileContains(fileSystem FileSystem, filePath string, pattern []byte) (bool, error) {
+	fh, err := fileSystem.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer fh.Close()
+
+	buffer := make([]byte, 64*1024)
+	overlap := len(pattern) - 1
+	filled := 0
+
+	for {
+		n, err := fh.Read(buffer[filled:])
+		filled += n
+
+		if bytes.Contains(buffer[:filled], pattern) {
+			return true, nil
+		}
+
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		// keep the tail to find the pattern crossing the chunks
+		if filled > overlap {
+			copy(buffer, buffer[filled-overlap:filled])
+			filled = overlap
+		}
+	}
+}
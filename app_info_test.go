@@ -0,0 +1,144 @@
+package sealights
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+func TestDetectApplication(t *testing.T) {
+	tests := []struct {
+		name           string
+		files          map[string]string
+		kind           AppKind
+		hooksSupported bool
+	}{
+		{"framework-dependent", map[string]string{
+			"app.runtimeconfig.json": `{"runtimeOptions":{"framework":{"name":"Microsoft.NETCore.App","version":"8.0.0"}}}`,
+			"app.dll":                "MZ",
+		}, AppKindFrameworkDependent, true},
+		{"self-contained trimmed", map[string]string{
+			"app.runtimeconfig.json": `{"runtimeOptions":{"includedFrameworks":[{"name":"Microsoft.NETCore.App","version":"8.0.0"}],` +
+				`"configProperties":{"System.StartupHookProvider.IsSupported":false}}}`,
+			"app": "\x7fELF",
+		}, AppKindSelfContained, false},
+		{"single-file", map[string]string{
+			"app": "\x7fELF" + string(bundleSignature) + `{"configProperties": {}}`,
+		}, AppKindSingleFile, true},
+		{"single-file trimmed", map[string]string{
+			"app": "\x7fELF" + string(bundleSignature) + `{"configProperties": {"System.StartupHookProvider.IsSupported": false}}`,
+		}, AppKindSingleFile, false},
+		{"native aot", map[string]string{
+			"app": "\x7fELF",
+		}, AppKindNativeAot, false},
+		{"net framework", map[string]string{
+			"Web.config":  "<configuration />",
+			"Global.asax": "",
+		}, AppKindNetFramework, false},
+		{"asp.net core hosted by iis", map[string]string{
+			"app.runtimeconfig.json": `{"runtimeOptions":{"framework":{"name":"Microsoft.NETCore.App","version":"8.0.0"}}}`,
+			"web.config":             "<configuration />",
+			"app.dll":                "MZ",
+		}, AppKindFrameworkDependent, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			publishDir := t.TempDir()
+			for name, content := range test.files {
+				if err := os.WriteFile(filepath.Join(publishDir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			app, err := DetectApplication(OsFileSystem{}, publishDir, "app")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if app.Kind != test.kind || app.StartupHooksSupported != test.hooksSupported {
+				t.Errorf("expected %s (hooks %v), got %s (hooks %v)", test.kind, test.hooksSupported, app.Kind, app.StartupHooksSupported)
+			}
+		})
+	}
+}
+
+func TestDetectApplicationRuntimeIdentifier(t *testing.T) {
+	publishDir := t.TempDir()
+	files := map[string]string{
+		"app.runtimeconfig.json": `{"runtimeOptions":{"framework":{"name":"Microsoft.NETCore.App","version":"8.0.0"}}}`,
+		"app.deps.json":          `{"runtimeTarget":{"name":".NETCoreApp,Version=v8.0/linux-musl-x64"}}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(publishDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// name is taken from the only runtimeconfig in the directory
+	app, err := DetectApplication(OsFileSystem{}, publishDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if app.Name != "app" || app.RuntimeIdentifier != "linux-musl-x64" {
+		t.Errorf("unexpected application %+v", app)
+	}
+	if app.IsSupportedRuntime() {
+		t.Error("musl runtime is not expected to be supported by the profiler")
+	}
+}
+
+func TestApplicationFromCommand(t *testing.T) {
+	tests := []struct {
+		command    string
+		publishDir string
+	}{
+		{"cd ${DEPS_DIR}/0/dotnet_publish && exec ./app --server.urls http://0.0.0.0:${PORT}", "/deps/0/dotnet_publish"},
+		{"cd ${HOME} && exec ./app", "/app"},
+		{"exec ./app", "/app"},
+	}
+
+	for _, test := range tests {
+		if publishDir := publishDirFromCommand(test.command, "/app", "/deps"); publishDir != filepath.FromSlash(test.publishDir) {
+			t.Errorf("'%s': expected publish dir '%s', got '%s'", test.command, test.publishDir, publishDir)
+		}
+	}
+
+	for _, target := range []string{"exec ./app --server.urls http://0.0.0.0:${PORT}", "exec dotnet ./app.dll", "./app"} {
+		if name := applicationNameFromCommand(target); name != "app" {
+			t.Errorf("'%s': expected name 'app', got '%s'", target, name)
+		}
+	}
+}
+
+func TestStartupHookModeFallsBackToProfiler(t *testing.T) {
+	options := &SealightsOptions{InstrumentationMode: InstrumentationModeStartupHook}
+	envManager := NewEnvManager(libbuildpack.NewLogger(os.Stdout), options)
+
+	envManager.App = &AppInfo{Kind: AppKindFrameworkDependent, StartupHooksSupported: true}
+	variables := envManager.GetVariables("/agent")
+	if _, found := variables[StartupHooksEnvVariable]; !found {
+		t.Error("startup hook is expected")
+	}
+	if _, found := variables["CORECLR_ENABLE_PROFILING"]; found {
+		t.Error("profiler is not expected")
+	}
+
+	envManager.App = &AppInfo{Kind: AppKindSingleFile, StartupHooksSupported: false}
+	variables = envManager.GetVariables("/agent")
+	if _, found := variables[StartupHooksEnvVariable]; found {
+		t.Error("startup hook is not expected for the trimmed application")
+	}
+	if variables["CORECLR_ENABLE_PROFILING"] != "1" {
+		t.Error("profiler is expected for the trimmed application")
+	}
+
+	envManager.App = &AppInfo{Kind: AppKindNativeAot, StartupHooksSupported: false}
+	variables = envManager.GetVariables("/agent")
+	if _, found := variables["CORECLR_ENABLE_PROFILING"]; found {
+		t.Error("profiler is not expected for native aot application")
+	}
+}
@@ -0,0 +1,511 @@
+package sealights
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const DefaultMaxArchiveSize = 1 << 30 // 1 GiB uncompressed
+const DefaultMaxArchiveEntries = 10000
+
+type ArchiveFormat int
+
+const (
+	ArchiveUnknown ArchiveFormat = iota
+	ArchiveZip
+	ArchiveTarGz
+	ArchiveTarXz
+	ArchiveTar
+)
+
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1f, 0x8b}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	tarMagic  = []byte("ustar")
+)
+
+const tarMagicOffset = 257
+
+var ErrUnknownArchiveFormat = errors.New("unknown archive format")
+var ErrArchiveTooLarge = errors.New("archive exceeds the allowed uncompressed size")
+var ErrArchiveTooManyEntries = errors.New("archive exceeds the allowed number of entries")
+
+func (format ArchiveFormat) String() string {
+	switch format {
+	case ArchiveZip:
+		return "zip"
+	case ArchiveTarGz:
+		return "tar.gz"
+	case ArchiveTarXz:
+		return "tar.xz"
+	case ArchiveTar:
+		return "tar"
+	default:
+		return "unknown"
+	}
+}
+
+// ArchiveExtractor unpacks agent packages. It doesn't trust the archive content:
+// entries escaping the target directory, links pointing outside of it and
+// archives exceeding the configured limits are rejected
+type ArchiveExtractor struct {
+	MaxSize    int64
+	MaxEntries int
+
+	// runs xz, there is no xz support in the standard library
+	Command    Command
+	FileSystem FileSystem
+
+	target  string
+	size    int64
+	entries int
+}
+
+func NewArchiveExtractor(maxSize int64, maxEntries int) *ArchiveExtractor {
+	return &ArchiveExtractor{MaxSize: maxSize, MaxEntries: maxEntries, Command: &libbuildpack.Command{}, FileSystem: OsFileSystem{}}
+}
+
+// DetectArchiveFormat checks magic bytes of the file. Nuget packages are zip archives
+func DetectArchiveFormat(fileSystem FileSystem, source string) (ArchiveFormat, error) {
+	fh, err := fileSystem.Open(source)
+	if err != nil {
+		return ArchiveUnknown, err
+	}
+	defer fh.Close()
+
+	header := make([]byte, tarMagicOffset+len(tarMagic))
+	n, err := io.ReadFull(fh, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return ArchiveUnknown, err
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, zipMagic):
+		return ArchiveZip, nil
+	case bytes.HasPrefix(header, gzipMagic):
+		return ArchiveTarGz, nil
+	case bytes.HasPrefix(header, xzMagic):
+		return ArchiveTarXz, nil
+	case len(header) == tarMagicOffset+len(tarMagic) && bytes.Equal(header[tarMagicOffset:], tarMagic):
+		return ArchiveTar, nil
+	}
+
+	return ArchiveUnknown, ErrUnknownArchiveFormat
+}
+
+func (ex *ArchiveExtractor) Extract(source string, target string) error {
+	format, err := DetectArchiveFormat(ex.FileSystem, source)
+	if err != nil {
+		return err
+	}
+
+	if err = ex.FileSystem.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+
+	// all checks compare real paths, so the target should be resolved as well
+	ex.target, err = filepath.Abs(target)
+	if err != nil {
+		return err
+	}
+	ex.target, err = ex.FileSystem.EvalSymlinks(ex.target)
+	if err != nil {
+		return err
+	}
+	ex.size = 0
+	ex.entries = 0
+
+	switch format {
+	case ArchiveZip:
+		err = ex.extractZip(source)
+	case ArchiveTarGz:
+		err = ex.extractTarGz(source)
+	case ArchiveTarXz:
+		err = ex.extractTarXz(source)
+	default:
+		err = ex.extractPlainTar(source)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return ex.verifySymlinks()
+}
+
+func (ex *ArchiveExtractor) extractZip(source string) error {
+	info, err := ex.FileSystem.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	fh, err := ex.FileSystem.Open(source)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	// zip directory is at the end of the file, so random access is required.
+	// Files of the host support it, content of the other file systems is buffered
+	readerAt, ok := fh.(io.ReaderAt)
+	if !ok {
+		data, err := io.ReadAll(fh)
+		if err != nil {
+			return err
+		}
+		readerAt = bytes.NewReader(data)
+	}
+
+	reader, err := zip.NewReader(readerAt, info.Size())
+	if err != nil {
+		return err
+	}
+
+	for _, file := range reader.File {
+		if err := ex.countEntry(); err != nil {
+			return err
+		}
+
+		destination, err := ex.resolvePath(file.Name)
+		if err != nil {
+			return err
+		}
+
+		info := file.FileInfo()
+		switch {
+		case info.IsDir():
+			err = ex.FileSystem.MkdirAll(destination, 0755)
+		case info.Mode()&os.ModeSymlink != 0:
+			err = ex.extractZipSymlink(file, destination)
+		default:
+			err = ex.extractZipFile(file, destination)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ex *ArchiveExtractor) extractZipFile(file *zip.File, destination string) error {
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return ex.writeFile(rc, destination, file.Mode().Perm())
+}
+
+func (ex *ArchiveExtractor) extractZipSymlink(file *zip.File, destination string) error {
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	linkTarget, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return err
+	}
+
+	return ex.createSymlink(string(linkTarget), destination)
+}
+
+func (ex *ArchiveExtractor) extractTarGz(source string) error {
+	fh, err := ex.FileSystem.Open(source)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	gz, err := gzip.NewReader(fh)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	return ex.extractTar(gz)
+}
+
+// Decompressed stream is read while xz is running, when the extraction fails
+// the pipe is closed and xz stops on the write error. xz reads the package by
+// its path, so the extracted files only go through the file system
+func (ex *ArchiveExtractor) extractTarXz(source string) error {
+	xz, output := io.Pipe()
+	var stderr bytes.Buffer
+
+	xzDone := make(chan error, 1)
+	go func() {
+		err := ex.Command.Execute("", output, &stderr, "xz", "--decompress", "--keep", "--stdout", source)
+		output.CloseWithError(err)
+		xzDone <- err
+	}()
+
+	err := ex.extractTar(xz)
+	if err == nil {
+		// tar padding after the last entry
+		io.Copy(io.Discard, xz)
+	}
+	xz.Close()
+	xzErr := <-xzDone
+
+	if err != nil {
+		return err
+	}
+	if xzErr != nil {
+		return fmt.Errorf("xz failed: %w: %s", xzErr, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+func (ex *ArchiveExtractor) extractPlainTar(source string) error {
+	fh, err := ex.FileSystem.Open(source)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	return ex.extractTar(fh)
+}
+
+func (ex *ArchiveExtractor) extractTar(source io.Reader) error {
+	reader := tar.NewReader(source)
+
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := ex.countEntry(); err != nil {
+			return err
+		}
+
+		destination, err := ex.resolvePath(header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = ex.FileSystem.MkdirAll(destination, 0755)
+		case tar.TypeReg:
+			err = ex.writeFile(reader, destination, os.FileMode(header.Mode).Perm())
+		case tar.TypeSymlink:
+			err = ex.createSymlink(header.Linkname, destination)
+		case tar.TypeLink:
+			err = ex.createHardlink(header.Linkname, destination)
+		case tar.TypeXGlobalHeader:
+			// pax global header carries no file
+		default:
+			return fmt.Errorf("unsupported archive entry '%s' of type %q", header.Name, header.Typeflag)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (ex *ArchiveExtractor) countEntry() error {
+	ex.entries++
+	if ex.MaxEntries > 0 && ex.entries > ex.MaxEntries {
+		return ErrArchiveTooManyEntries
+	}
+
+	return nil
+}
+
+// Get absolute path of the archive entry and make sure it stays inside of the target directory
+func (ex *ArchiveExtractor) resolvePath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || strings.HasPrefix(name, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("illegal archive entry '%s': absolute path", name)
+	}
+
+	destination := filepath.Join(ex.target, filepath.FromSlash(name))
+	if !ex.isInsideTarget(destination) {
+		return "", fmt.Errorf("illegal archive entry '%s': path escapes target directory", name)
+	}
+
+	// symlinks extracted earlier could redirect the entry outside
+	if err := ex.checkRealParent(destination); err != nil {
+		return "", fmt.Errorf("illegal archive entry '%s': %w", name, err)
+	}
+
+	return destination, nil
+}
+
+func (ex *ArchiveExtractor) isInsideTarget(path string) bool {
+	relative, err := filepath.Rel(ex.target, path)
+	if err != nil {
+		return false
+	}
+
+	return relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// Resolve symlinks of the deepest existing parent directory and check it is still inside of the target directory
+func (ex *ArchiveExtractor) checkRealParent(destination string) error {
+	for dir := filepath.Dir(destination); ex.isInsideTarget(dir); dir = filepath.Dir(dir) {
+		if _, err := ex.FileSystem.Lstat(dir); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+
+		resolved, err := ex.FileSystem.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+		if !ex.isInsideTarget(resolved) {
+			return errors.New("parent directory leads outside of the target directory")
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+// Check that symlink resolves to a location inside of the target directory
+func (ex *ArchiveExtractor) checkSymlink(link string) error {
+	resolved, err := ex.FileSystem.EvalSymlinks(link)
+	if err != nil {
+		return fmt.Errorf("illegal symlink '%s': %w", link, err)
+	}
+	if !ex.isInsideTarget(resolved) {
+		return fmt.Errorf("illegal symlink '%s': resolves outside of the target directory", link)
+	}
+
+	return nil
+}
+
+func (ex *ArchiveExtractor) createSymlink(linkTarget string, destination string) error {
+	if filepath.IsAbs(linkTarget) || strings.HasPrefix(linkTarget, "/") {
+		return fmt.Errorf("illegal symlink '%s': absolute target '%s'", destination, linkTarget)
+	}
+
+	resolved := filepath.Join(filepath.Dir(destination), filepath.FromSlash(linkTarget))
+	if !ex.isInsideTarget(resolved) {
+		return fmt.Errorf("illegal symlink '%s': target '%s' is outside of the target directory", destination, linkTarget)
+	}
+
+	if err := ex.FileSystem.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return err
+	}
+
+	ex.FileSystem.Remove(destination)
+	if err := ex.FileSystem.Symlink(linkTarget, destination); err != nil {
+		return err
+	}
+
+	// the link could be dangling until the rest of the archive is extracted,
+	// all links are verified once again in the end
+	if _, err := ex.FileSystem.Stat(destination); err == nil {
+		if err = ex.checkSymlink(destination); err != nil {
+			ex.FileSystem.Remove(destination)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ex *ArchiveExtractor) createHardlink(linkTarget string, destination string) error {
+	source, err := ex.resolvePath(linkTarget)
+	if err != nil {
+		return err
+	}
+
+	// only regular files are accepted - hardlink to a symlink could lead outside
+	info, err := ex.FileSystem.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("illegal hardlink '%s': target '%s' is not a regular file", destination, linkTarget)
+	}
+
+	if err := ex.FileSystem.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return err
+	}
+
+	ex.FileSystem.Remove(destination)
+	return ex.FileSystem.Link(source, destination)
+}
+
+func (ex *ArchiveExtractor) writeFile(source io.Reader, destination string, mode os.FileMode) error {
+	// do not follow symlink which could be created by the previous entries
+	if info, err := ex.FileSystem.Lstat(destination); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err = ex.FileSystem.Remove(destination); err != nil {
+			return err
+		}
+	}
+
+	var limited io.Reader = source
+	if ex.MaxSize > 0 {
+		// read one extra byte to catch entries exceeding the limit
+		limited = io.LimitReader(source, ex.MaxSize-ex.size+1)
+	}
+
+	counter := &countingReader{reader: limited}
+	err := writeFile(ex.FileSystem, counter, destination, mode|0600)
+	ex.size += counter.count
+	if err != nil {
+		return err
+	}
+
+	if ex.MaxSize > 0 && ex.size > ex.MaxSize {
+		return ErrArchiveTooLarge
+	}
+
+	return nil
+}
+
+// Verify all symlinks once the archive is extracted. Links could be
+// dangling during extraction and resolved by the subsequent entries
+func (ex *ArchiveExtractor) verifySymlinks() error {
+	return ex.FileSystem.WalkDir(ex.target, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.Type()&os.ModeSymlink == 0 {
+			return nil
+		}
+
+		return ex.checkSymlink(path)
+	})
+}
+
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	cr.count += int64(n)
+	return n, err
+}
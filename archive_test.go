@@ -0,0 +1,285 @@
+package sealights
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testArchiveEntry struct {
+	Name     string
+	Content  string
+	Type     byte
+	Linkname string
+}
+
+func writeTestTar(t *testing.T, path string, compress bool, entries []testArchiveEntry) {
+	t.Helper()
+
+	if err := os.WriteFile(path, buildTestTar(t, compress, entries), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func buildTestTar(t *testing.T, compress bool, entries []testArchiveEntry) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	tw := tar.NewWriter(&buffer)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.Name, Typeflag: entry.Type, Linkname: entry.Linkname, Mode: 0644}
+		if header.Typeflag == 0 {
+			header.Typeflag = tar.TypeReg
+		}
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(entry.Content))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.Content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buffer.Bytes()
+	if compress {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(data)
+		gz.Close()
+		data = compressed.Bytes()
+	}
+
+	return data
+}
+
+func writeTestZip(t *testing.T, path string, entries []testArchiveEntry) {
+	t.Helper()
+
+	if err := os.WriteFile(path, buildTestZip(t, entries), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func buildTestZip(t *testing.T, entries []testArchiveEntry) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	zw := zip.NewWriter(&buffer)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.Name, Method: zip.Deflate}
+		header.SetMode(0644)
+		content := entry.Content
+		if entry.Type == tar.TypeSymlink {
+			header.SetMode(os.ModeSymlink | 0777)
+			content = entry.Linkname
+		}
+
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+// archive is extracted into 'root/target', files escaping it would appear in 'root'
+func extractTestArchive(t *testing.T, write func(path string), maxSize int64, maxEntries int) (string, error) {
+	t.Helper()
+
+	root := t.TempDir()
+	source := filepath.Join(t.TempDir(), "package")
+	write(source)
+
+	target := filepath.Join(root, "target")
+	err := NewArchiveExtractor(maxSize, maxEntries).Extract(source, target)
+
+	return root, err
+}
+
+func TestArchiveExtractorRejectsMaliciousTar(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []testArchiveEntry
+	}{
+		{"parent directory", []testArchiveEntry{{Name: "../evil.txt", Content: "evil"}}},
+		{"nested parent directory", []testArchiveEntry{{Name: "dir/../../evil.txt", Content: "evil"}}},
+		{"absolute path", []testArchiveEntry{{Name: "/tmp/evil.txt", Content: "evil"}}},
+		{"backslash parent directory", []testArchiveEntry{{Name: "..\\evil.txt", Content: "evil"}}},
+		{"symlink outside", []testArchiveEntry{{Name: "link", Type: tar.TypeSymlink, Linkname: "../"}}},
+		{"absolute symlink", []testArchiveEntry{{Name: "link", Type: tar.TypeSymlink, Linkname: "/etc"}}},
+		{"symlink chain outside", []testArchiveEntry{
+			{Name: "first", Type: tar.TypeSymlink, Linkname: "dir/second"},
+			{Name: "dir/second", Type: tar.TypeSymlink, Linkname: "../../evil.txt"},
+		}},
+		{"file through symlink", []testArchiveEntry{
+			{Name: "dir", Type: tar.TypeSymlink, Linkname: "."},
+			{Name: "dir/../../evil.txt", Content: "evil"},
+		}},
+		{"hardlink outside", []testArchiveEntry{{Name: "link", Type: tar.TypeLink, Linkname: "../evil.txt"}}},
+		{"hardlink to symlink", []testArchiveEntry{
+			{Name: "file", Content: "data"},
+			{Name: "symlink", Type: tar.TypeSymlink, Linkname: "file"},
+			{Name: "hardlink", Type: tar.TypeLink, Linkname: "symlink"},
+		}},
+		{"unsupported entry", []testArchiveEntry{{Name: "device", Type: tar.TypeChar}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root, err := extractTestArchive(t, func(path string) {
+				writeTestTar(t, path, true, test.entries)
+			}, DefaultMaxArchiveSize, DefaultMaxArchiveEntries)
+
+			if err == nil {
+				t.Fatal("expected extraction to fail")
+			}
+			if _, err := os.Lstat(filepath.Join(root, "evil.txt")); err == nil {
+				t.Error("file is written outside of the target directory")
+			}
+		})
+	}
+}
+
+func TestArchiveExtractorRejectsMaliciousZip(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []testArchiveEntry
+	}{
+		{"parent directory", []testArchiveEntry{{Name: "../evil.txt", Content: "evil"}}},
+		{"absolute path", []testArchiveEntry{{Name: "/evil.txt", Content: "evil"}}},
+		{"symlink outside", []testArchiveEntry{{Name: "link", Type: tar.TypeSymlink, Linkname: "../evil.txt"}}},
+		{"absolute symlink", []testArchiveEntry{{Name: "link", Type: tar.TypeSymlink, Linkname: "/etc/passwd"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root, err := extractTestArchive(t, func(path string) {
+				writeTestZip(t, path, test.entries)
+			}, DefaultMaxArchiveSize, DefaultMaxArchiveEntries)
+
+			if err == nil {
+				t.Fatal("expected extraction to fail")
+			}
+			if _, err := os.Lstat(filepath.Join(root, "evil.txt")); err == nil {
+				t.Error("file is written outside of the target directory")
+			}
+		})
+	}
+}
+
+func TestArchiveExtractorLimits(t *testing.T) {
+	entries := []testArchiveEntry{
+		{Name: "first.txt", Content: strings.Repeat("a", 64)},
+		{Name: "second.txt", Content: strings.Repeat("b", 64)},
+		{Name: "third.txt", Content: strings.Repeat("c", 64)},
+	}
+
+	tests := []struct {
+		name       string
+		maxSize    int64
+		maxEntries int
+		expected   error
+	}{
+		{"oversized entry", 32, 0, ErrArchiveTooLarge},
+		{"oversized total", 150, 0, ErrArchiveTooLarge},
+		{"too many entries", 0, 2, ErrArchiveTooManyEntries},
+		{"within limits", 192, 3, nil},
+	}
+
+	for _, test := range tests {
+		for _, format := range []string{"tar.gz", "zip"} {
+			t.Run(test.name+" "+format, func(t *testing.T) {
+				_, err := extractTestArchive(t, func(path string) {
+					if format == "zip" {
+						writeTestZip(t, path, entries)
+					} else {
+						writeTestTar(t, path, true, entries)
+					}
+				}, test.maxSize, test.maxEntries)
+
+				if !errors.Is(err, test.expected) {
+					t.Errorf("expected error %v, got %v", test.expected, err)
+				}
+			})
+		}
+	}
+}
+
+func TestArchiveExtractorExtractsLinksInside(t *testing.T) {
+	root, err := extractTestArchive(t, func(path string) {
+		writeTestTar(t, path, false, []testArchiveEntry{
+			{Name: "lib/agent.so", Content: "library"},
+			{Name: "agent.so", Type: tar.TypeSymlink, Linkname: "lib/agent.so"},
+			{Name: "dangling", Type: tar.TypeSymlink, Linkname: "later/file"},
+			{Name: "later/file", Content: "later"},
+			{Name: "copy.so", Type: tar.TypeLink, Linkname: "lib/agent.so"},
+		})
+	}, DefaultMaxArchiveSize, DefaultMaxArchiveEntries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"agent.so", "dangling", "copy.so"} {
+		if _, err := os.Stat(filepath.Join(root, "target", name)); err != nil {
+			t.Errorf("entry '%s' is not extracted: %v", name, err)
+		}
+	}
+}
+
+func TestDetectArchiveFormat(t *testing.T) {
+	dir := t.TempDir()
+	entries := []testArchiveEntry{{Name: "version.txt", Content: "1.0.0"}}
+
+	writeTestZip(t, filepath.Join(dir, "package.zip"), entries)
+	writeTestTar(t, filepath.Join(dir, "package.tar.gz"), true, entries)
+	writeTestTar(t, filepath.Join(dir, "package.tar"), false, entries)
+	os.WriteFile(filepath.Join(dir, "package.tar.xz"), append([]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, "payload"...), 0644)
+	os.WriteFile(filepath.Join(dir, "package.txt"), []byte("plain text"), 0644)
+	os.WriteFile(filepath.Join(dir, "empty"), nil, 0644)
+
+	tests := []struct {
+		file     string
+		expected ArchiveFormat
+		err      error
+	}{
+		{"package.zip", ArchiveZip, nil},
+		{"package.tar.gz", ArchiveTarGz, nil},
+		{"package.tar", ArchiveTar, nil},
+		{"package.tar.xz", ArchiveTarXz, nil},
+		{"package.txt", ArchiveUnknown, ErrUnknownArchiveFormat},
+		{"empty", ArchiveUnknown, ErrUnknownArchiveFormat},
+	}
+
+	for _, test := range tests {
+		format, err := DetectArchiveFormat(OsFileSystem{}, filepath.Join(dir, test.file))
+		if format != test.expected || !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %s (%v), got %s (%v)", test.file, test.expected, test.err, format, err)
+		}
+	}
+}
+
+func TestArchiveExtractorUnknownFormat(t *testing.T) {
+	_, err := extractTestArchive(t, func(path string) {
+		os.WriteFile(path, []byte("not an archive"), 0644)
+	}, DefaultMaxArchiveSize, DefaultMaxArchiveEntries)
+
+	if !errors.Is(err, ErrUnknownArchiveFormat) {
+		t.Errorf("expected unknown format error, got %v", err)
+	}
+}
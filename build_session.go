@@ -0,0 +1,113 @@
+package sealights
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const BuildSessionIdFileName = "buildSessionId.txt"
+const BuildSessionIdFileArgument = "buildSessionIdFile"
+
+// verbs of the agent creating the build session
+var buildSessionVerbs = []string{"config", "prConfig"}
+
+// options of the agent connection reused for the build session creation
+var buildSessionConnectionArguments = []string{"token", "tokenFile", "proxy", "proxyUsername", "proxyPassword"}
+
+var ErrBuildSessionIdNotCreated = errors.New("agent didn't create build session id file")
+
+// BuildSession runs the config verb of the installed agent at staging, so the
+// build session id doesn't have to be created by the pipeline before the push
+type BuildSession struct {
+	Log        *libbuildpack.Logger
+	Options    *SealightsOptions
+	Command    Command
+	FileSystem FileSystem
+}
+
+func NewBuildSession(log *libbuildpack.Logger, options *SealightsOptions, command Command) *BuildSession {
+	return &BuildSession{Log: log, Options: options, Command: command, FileSystem: OsFileSystem{}}
+}
+
+// Create runs the agent in its directory, the id file is written into the working directory
+// and stays in the droplet. The file is passed to the agent at runtime with 'buildSessionIdFile'
+func (bs *BuildSession) Create(agentDirAbsolute string, agentDirForRuntime string) error {
+	arguments := bs.arguments()
+	bs.Log.Debug("Sealights. Create build session: %s", maskSensitiveData(strings.Join(arguments, " ")))
+
+	agentExecutable := filepath.Join(agentDirAbsolute, agentExecutableName())
+	err := bs.Command.Execute(agentDirAbsolute, bs.Log.Output(), bs.Log.Output(), agentExecutable, arguments...)
+	if err != nil {
+		return fmt.Errorf("failed to run agent '%s' verb: %w", bs.Options.BuildSessionVerb, err)
+	}
+
+	data, err := bs.FileSystem.ReadFile(filepath.Join(agentDirAbsolute, BuildSessionIdFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrBuildSessionIdNotCreated
+		}
+		return err
+	}
+
+	buildSessionId := strings.TrimSpace(string(data))
+	if buildSessionId == "" {
+		return ErrBuildSessionIdNotCreated
+	}
+
+	bs.Log.Info("Sealights. Build session is created (id: %s)", buildSessionId)
+	bs.Options.SlArguments[BuildSessionIdFileArgument] = filepath.Join(agentDirForRuntime, BuildSessionIdFileName)
+
+	return nil
+}
+
+// Get verb with the build session options, connection options are taken from 'cli' if not provided
+func (bs *BuildSession) arguments() []string {
+	values := map[string]string{}
+	for _, key := range buildSessionConnectionArguments {
+		if value, provided := bs.Options.SlArguments[key]; provided {
+			values[key] = value
+		}
+	}
+
+	for key, value := range bs.Options.BuildSessionArguments {
+		values[key] = value
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	arguments := []string{bs.Options.BuildSessionVerb}
+	for _, key := range keys {
+		arguments = append(arguments, "--"+key, values[key])
+	}
+
+	return arguments
+}
+
+func isBuildSessionVerb(verb string) bool {
+	for _, buildSessionVerb := range buildSessionVerbs {
+		if verb == buildSessionVerb {
+			return true
+		}
+	}
+
+	return false
+}
+
+func agentExecutableName() string {
+	if runtime.GOOS == "windows" {
+		return WindowsAgentName
+	}
+
+	return LinuxAgentName
+}
@@ -0,0 +1,68 @@
+package sealights
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// Agent command writing the build session id file into its working directory
+type fakeAgentCommand struct {
+	buildSessionId string
+	arguments      []string
+}
+
+func (c *fakeAgentCommand) Execute(dir string, _ io.Writer, _ io.Writer, _ string, arguments ...string) error {
+	c.arguments = arguments
+	if c.buildSessionId == "" {
+		return nil
+	}
+
+	return os.WriteFile(filepath.Join(dir, BuildSessionIdFileName), []byte(c.buildSessionId+"\n"), 0644)
+}
+
+func TestBuildSessionCreate(t *testing.T) {
+	options := &SealightsOptions{
+		BuildSessionVerb:      "config",
+		SlArguments:           map[string]string{"tokenFile": "token.txt", "labId": "lab"},
+		BuildSessionArguments: map[string]string{"appName": "orders", "buildName": "42"},
+	}
+	command := &fakeAgentCommand{buildSessionId: "bsid"}
+	buildSession := NewBuildSession(libbuildpack.NewLogger(&bytes.Buffer{}), options, command)
+
+	agentDir := t.TempDir()
+	if err := buildSession.Create(agentDir, "/home/vcap/app/sealights"); err != nil {
+		t.Fatal(err)
+	}
+
+	// connection options are reused, the rest of 'cli' isn't passed to the verb
+	expected := "config --appName orders --buildName 42 --tokenFile token.txt"
+	if arguments := strings.Join(command.arguments, " "); arguments != expected {
+		t.Errorf("expected arguments '%s', got '%s'", expected, arguments)
+	}
+	if idFile := options.SlArguments[BuildSessionIdFileArgument]; idFile != filepath.Join("/home/vcap/app/sealights", BuildSessionIdFileName) {
+		t.Errorf("unexpected build session id file '%s'", idFile)
+	}
+}
+
+func TestBuildSessionCreateWithoutIdFile(t *testing.T) {
+	options := &SealightsOptions{
+		BuildSessionVerb:      "config",
+		SlArguments:           map[string]string{},
+		BuildSessionArguments: map[string]string{},
+	}
+	buildSession := NewBuildSession(libbuildpack.NewLogger(&bytes.Buffer{}), options, &fakeAgentCommand{})
+
+	if err := buildSession.Create(t.TempDir(), "/home/vcap/app/sealights"); !errors.Is(err, ErrBuildSessionIdNotCreated) {
+		t.Errorf("expected missing id file error, got %v", err)
+	}
+	if _, exists := options.SlArguments[BuildSessionIdFileArgument]; exists {
+		t.Error("id file must not be passed to the agent")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	sealights "github.com/Sealights/libbuildpack-sealights"
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// Entrypoint of the Sealights Cloud Native Buildpack. The same binary is linked as
+// bin/detect and bin/build, and it's copied into the layer as exec.d executable.
+//
+// detect: <platform dir> <plan path>
+// build:  <layers dir> <platform dir> <plan path>
+func main() {
+	logger := libbuildpack.NewLogger(os.Stderr)
+
+	switch filepath.Base(os.Args[0]) {
+	case "detect":
+		detect(logger)
+	case "build":
+		build(logger)
+	default:
+		sealights.RunCNBExecD(logger)
+	}
+}
+
+func detect(logger *libbuildpack.Logger) {
+	platformDir := argOrEnv(1, "CNB_PLATFORM_DIR")
+
+	buildpack, err := sealights.NewCNBBuildpack(logger, platformDir)
+	if err != nil {
+		logger.Error("Sealights. %s", err.Error())
+		os.Exit(1)
+	}
+
+	os.Exit(buildpack.Detect())
+}
+
+func build(logger *libbuildpack.Logger) {
+	layersDir := argOrEnv(1, "CNB_LAYERS_DIR")
+	platformDir := argOrEnv(2, "CNB_PLATFORM_DIR")
+
+	buildpack, err := sealights.NewCNBBuildpack(logger, platformDir)
+	if err != nil {
+		logger.Error("Sealights. %s", err.Error())
+		os.Exit(1)
+	}
+
+	if err = buildpack.Build(layersDir); err != nil {
+		logger.Error("Sealights. Build failed: %s", err.Error())
+		os.Exit(1)
+	}
+}
+
+// newer buildpack API versions provide directories in env variables instead of arguments
+func argOrEnv(index int, envVariable string) string {
+	if value := os.Getenv(envVariable); value != "" {
+		return value
+	}
+
+	if len(os.Args) > index {
+		return os.Args[index]
+	}
+
+	return ""
+}
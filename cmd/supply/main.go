@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	sealights "github.com/Sealights/libbuildpack-sealights"
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// Entrypoint of the standalone Sealights supply buildpack:
+// cf push -b sealights -b dotnet_core
+//
+// arguments: <build dir> <cache dir> <deps dir> <deps index>
+func main() {
+	logger := libbuildpack.NewLogger(os.Stdout)
+
+	buildpackDir, err := libbuildpack.GetBuildpackDir()
+	if err != nil {
+		logger.Error("Unable to determine buildpack directory: %s", err.Error())
+		os.Exit(9)
+	}
+
+	manifest, err := libbuildpack.NewManifest(buildpackDir, logger, time.Now())
+	if err != nil {
+		logger.Error("Unable to load buildpack manifest: %s", err.Error())
+		os.Exit(10)
+	}
+
+	if len(os.Args) < 5 {
+		logger.Error("Usage: supply <build dir> <cache dir> <deps dir> <deps index>")
+		os.Exit(11)
+	}
+
+	stager := libbuildpack.NewStager(os.Args[1:], logger, manifest)
+
+	if err = sealights.RunSupplyBuildpack(stager, logger); err != nil {
+		os.Exit(12)
+	}
+}
@@ -0,0 +1,420 @@
+package sealights
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const CNBToolName = "sl-cnb"
+const CNBLayerName = "sealights"
+const CNBExecDName = "sealights"
+const CNBListenerFileName = "listener.json"
+
+// exit codes defined by the buildpack specification
+const (
+	CNBDetectPass = 0
+	CNBDetectFail = 100
+)
+
+var ErrUnsupportedInCNB = errors.New("option is not supported by the cloud native buildpack")
+
+var buildpackVersionPattern = regexp.MustCompile(`(?m)^\s*version\s*=\s*"([^"]*)"`)
+var layerMetadataPattern = regexp.MustCompile(`(?m)^\s*(\w+)\s*=\s*("(?:[^"\\]|\\.)*")\s*$`)
+
+// CNBBuildpack implements detect and build phases of the Cloud Native Buildpacks
+// specification. The agent is contributed as a cached launch layer, the profiler
+// is configured by the layer launch env and the background test listener
+// is started by the exec.d executable before the application
+type CNBBuildpack struct {
+	Log          *libbuildpack.Logger
+	Command      Command
+	BuildpackDir string
+	PlatformDir  string
+}
+
+// CNBListener is stored in the layer to start the background test listener at launch
+type CNBListener struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+type CNBLayer struct {
+	Name     string
+	Path     string
+	Metadata map[string]string
+}
+
+func NewCNBBuildpack(log *libbuildpack.Logger, platformDir string) (*CNBBuildpack, error) {
+	buildpackDir := os.Getenv("CNB_BUILDPACK_DIR")
+	if buildpackDir == "" {
+		executable, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		buildpackDir = filepath.Dir(filepath.Dir(executable))
+	}
+
+	return &CNBBuildpack{Log: log, Command: &libbuildpack.Command{}, BuildpackDir: buildpackDir, PlatformDir: platformDir}, nil
+}
+
+// Detect passes if a Sealights service binding is provided
+func (cnb *CNBBuildpack) Detect() int {
+	binding, err := findSealightsBinding(cnb.bindingRoot())
+	if err != nil {
+		cnb.Log.Warning("Sealights. Failed to read service bindings: %v", err)
+		return CNBDetectFail
+	}
+
+	if binding == "" {
+		return CNBDetectFail
+	}
+
+	return CNBDetectPass
+}
+
+func (cnb *CNBBuildpack) Build(layersDir string) error {
+	conf, err := cnb.configuration()
+	if err != nil {
+		return err
+	}
+	if !conf.UseSealights() {
+		cnb.Log.Info("Sealights service binding isn't provided")
+		return nil
+	}
+
+	cnb.Log.BeginStep("Sealights. Service binding '%s' is provided", conf.ServiceName)
+
+	options := conf.Value
+	if options.CustomCommand != "" {
+		return fmt.Errorf("%w: 'customCommand'", ErrUnsupportedInCNB)
+	}
+	if options.Verb != "" && options.Verb != "startBackgroundTestListener" {
+		return fmt.Errorf("%w: verb '%s'", ErrUnsupportedInCNB, options.Verb)
+	}
+	if options.AgentPort == AgentPortAuto {
+		return fmt.Errorf("%w: agentPort '%s'", ErrUnsupportedInCNB, AgentPortAuto)
+	}
+	if options.UseSupervisor || options.UseSidecar {
+		return fmt.Errorf("%w: 'useSupervisor' and 'useSidecar'", ErrUnsupportedInCNB)
+	}
+
+	steps := NewStagingSteps(cnb.Log, options.FailurePolicy)
+	defer steps.PrintSummary()
+
+	_, err = steps.Run(StepExpandTemplates, func() error {
+		return ExpandOptionTemplates(options)
+	})
+	if err != nil {
+		return err
+	}
+
+	layer, err := readCNBLayer(layersDir, CNBLayerName)
+	if err != nil {
+		return err
+	}
+
+	agentInstaller := NewAgentInstaller(cnb.Log, options)
+	agentInstaller.Command = cnb.Command
+
+	var agentVersion string
+	installed, err := steps.Run(StepInstallAgent, func() (err error) {
+		agentVersion, err = cnb.contributeAgent(layer, agentInstaller)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !installed {
+		steps.Skip(StepWriteLayer, "agent is not installed")
+		return layer.Remove()
+	}
+	cnb.Log.Info("Sealights. Agent is installed (version: %s)", agentVersion)
+
+	if options.BuildSessionVerb != "" {
+		_, err = steps.Run(StepCreateBuildSession, func() error {
+			buildSession := NewBuildSession(cnb.Log, options, cnb.Command)
+			return buildSession.Create(layer.Path, layer.Path)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = steps.Run(StepWriteLaunchEnv, func() error {
+		envManager := NewEnvManager(cnb.Log, options)
+		variables := envManager.GetVariables(layer.Path)
+		variables[StagingReportEnvVariable] = filepath.Join(layer.Path, StagingReportFileName)
+
+		return layer.WriteLaunchEnv(variables)
+	})
+	if err != nil {
+		return err
+	}
+
+	if options.Verb == "startBackgroundTestListener" {
+		_, err = steps.Run(StepConfigureListener, func() error {
+			return cnb.configureListener(layer, options)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = steps.Run(StepWriteStagingReport, func() error {
+		report := NewStagingReport(conf, agentInstaller, agentVersion, nil, steps)
+		return report.Write(OsFileSystem{}, layer.Path)
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = steps.Run(StepWriteLayer, layer.Write)
+	return err
+}
+
+// Reuse the cached layer when the same pinned version is requested, otherwise download the agent
+func (cnb *CNBBuildpack) contributeAgent(layer *CNBLayer, agentInstaller *AgentInstaller) (string, error) {
+	url, version, err := agentInstaller.ResolvePackage()
+	if err != nil {
+		return "", err
+	}
+
+	pinned := version != DefaultVersion && version != "custom"
+	if pinned && layer.Metadata["url"] == url && layer.Metadata["agentVersion"] != "" {
+		cnb.Log.Info("Sealights. Reusing cached layer (%s)", url)
+		agentInstaller.PackageUrl = url
+		agentInstaller.PackageSha256 = layer.Metadata["sha256"]
+		return layer.Metadata["agentVersion"], nil
+	}
+
+	if err = os.RemoveAll(layer.Path); err != nil {
+		return "", err
+	}
+
+	agentVersion, err := agentInstaller.InstallPackageToDir(url, layer.Path)
+	if err != nil {
+		return "", err
+	}
+
+	layer.Metadata = map[string]string{
+		"url":          agentInstaller.PackageUrl,
+		"sha256":       agentInstaller.PackageSha256,
+		"agentVersion": agentVersion,
+	}
+
+	return agentVersion, nil
+}
+
+// Store listener command in the layer and install exec.d executable which starts it.
+// The same binary is used for the buildpack phases and exec.d
+func (cnb *CNBBuildpack) configureListener(layer *CNBLayer, options *SealightsOptions) error {
+	launcher := &Launcher{Log: cnb.Log, Options: options, AgentDirForRuntime: layer.Path, FileSystem: OsFileSystem{}}
+
+	listener := CNBListener{Command: launcher.agentFullPath(), Args: launcher.agentArguments()}
+
+	data, err := json.Marshal(listener)
+	if err != nil {
+		return err
+	}
+
+	if err = os.WriteFile(filepath.Join(layer.Path, CNBListenerFileName), data, 0600); err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	source, err := os.Open(executable)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	return writeToFile(source, filepath.Join(layer.Path, "exec.d", CNBExecDName), 0755)
+}
+
+func (cnb *CNBBuildpack) configuration() (*Configuration, error) {
+	conf := &Configuration{Log: cnb.Log, ToolName: CNBToolName, BuildpackVersion: cnb.buildpackVersion(), FileSystem: OsFileSystem{}}
+
+	err := conf.parseServiceBindings(cnb.bindingRoot())
+	if err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// Bindings are provided in the platform directory during the build if the root isn't set explicitly
+func (cnb *CNBBuildpack) bindingRoot() string {
+	if root := os.Getenv(ServiceBindingRootEnvVariable); root != "" {
+		return root
+	}
+
+	if cnb.PlatformDir != "" {
+		return filepath.Join(cnb.PlatformDir, "bindings")
+	}
+
+	return ""
+}
+
+func (cnb *CNBBuildpack) buildpackVersion() string {
+	data, err := os.ReadFile(filepath.Join(cnb.BuildpackDir, "buildpack.toml"))
+	if err != nil {
+		cnb.Log.Warning("Failed to get buildpack version")
+		return "unknown"
+	}
+
+	match := buildpackVersionPattern.FindSubmatch(data)
+	if match == nil {
+		return "unknown"
+	}
+
+	return string(match[1])
+}
+
+// RunCNBExecD starts the background test listener configured in the layer. It is
+// executed by the launcher before the application, so it never fails to not block the start
+func RunCNBExecD(log *libbuildpack.Logger) {
+	executable, err := os.Executable()
+	if err != nil {
+		log.Warning("Sealights. Failed to start background test listener: %v", err)
+		return
+	}
+
+	layerPath := filepath.Dir(filepath.Dir(executable))
+	data, err := os.ReadFile(filepath.Join(layerPath, CNBListenerFileName))
+	if err != nil {
+		log.Warning("Sealights. Failed to start background test listener: %v", err)
+		return
+	}
+
+	var listener CNBListener
+	if err = json.Unmarshal(data, &listener); err != nil {
+		log.Warning("Sealights. Failed to start background test listener: %v", err)
+		return
+	}
+
+	// exec.d output is reserved for the env variables, so the agent writes to stderr
+	cmd := exec.Command(listener.Command, expandInstanceVariables(listener.Args)...)
+	cmd.Env = withoutProfilerVariables(os.Environ())
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	if err = cmd.Run(); err != nil {
+		log.Warning("Sealights. Failed to start background test listener: %v", err)
+	}
+}
+
+// Launch env of the layer enables the profiler for every process,
+// but it should not be attached to the agent itself
+func withoutProfilerVariables(environment []string) []string {
+	var result []string
+	for _, variable := range environment {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if isProfilerVariable(name) || strings.EqualFold(name, StartupHooksEnvVariable) {
+			continue
+		}
+
+		result = append(result, variable)
+	}
+
+	return result
+}
+
+func readCNBLayer(layersDir string, name string) (*CNBLayer, error) {
+	layer := &CNBLayer{Name: name, Path: filepath.Join(layersDir, name), Metadata: map[string]string{}}
+
+	data, err := os.ReadFile(layer.Path + ".toml")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return layer, nil
+		}
+		return nil, err
+	}
+
+	inMetadata := false
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inMetadata = line == "[metadata]"
+			continue
+		}
+
+		match := layerMetadataPattern.FindStringSubmatch(line)
+		if !inMetadata || match == nil {
+			continue
+		}
+
+		if value, err := strconv.Unquote(match[2]); err == nil {
+			layer.Metadata[match[1]] = value
+		}
+	}
+
+	return layer, scanner.Err()
+}
+
+// WriteLaunchEnv writes variables as launch env files of the layer
+func (layer *CNBLayer) WriteLaunchEnv(variables map[string]string) error {
+	envDir := filepath.Join(layer.Path, "env.launch")
+	if err := os.MkdirAll(envDir, 0755); err != nil {
+		return err
+	}
+
+	for key, value := range variables {
+		// startup hooks provided by other buildpacks or the application are kept
+		if key == StartupHooksEnvVariable {
+			err := os.WriteFile(filepath.Join(envDir, key+".delim"), []byte(string(os.PathListSeparator)), 0644)
+			if err != nil {
+				return err
+			}
+			if err = os.WriteFile(filepath.Join(envDir, key+".append"), []byte(value), 0644); err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := os.WriteFile(filepath.Join(envDir, key+".override"), []byte(value), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Remove the layer with its metadata, otherwise the lifecycle restores the layer from the previous build
+func (layer *CNBLayer) Remove() error {
+	if err := os.RemoveAll(layer.Path); err != nil {
+		return err
+	}
+
+	if err := os.Remove(layer.Path + ".toml"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Write layer content metadata, the agent is required at launch and cached between builds
+func (layer *CNBLayer) Write() error {
+	var sb strings.Builder
+	sb.WriteString("[types]\nlaunch = true\ncache = true\n\n[metadata]\n")
+	for _, key := range []string{"url", "sha256", "agentVersion"} {
+		sb.WriteString(fmt.Sprintf("%s = %s\n", key, strconv.Quote(layer.Metadata[key])))
+	}
+
+	return os.WriteFile(layer.Path+".toml", []byte(sb.String()), 0644)
+}
@@ -0,0 +1,66 @@
+package sealights
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+func TestCNBLayerMetadata(t *testing.T) {
+	layersDir := t.TempDir()
+	layer := &CNBLayer{Name: CNBLayerName, Path: filepath.Join(layersDir, CNBLayerName), Metadata: map[string]string{
+		"url":          "https://agents.example.com/agent.tar.gz?name=\"agent\"",
+		"sha256":       "abc",
+		"agentVersion": "1.0.0",
+	}}
+	if err := layer.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := readCNBLayer(layersDir, CNBLayerName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.Metadata, layer.Metadata) {
+		t.Errorf("expected metadata %v, got %v", layer.Metadata, restored.Metadata)
+	}
+
+	missing, err := readCNBLayer(layersDir, "missing")
+	if err != nil || len(missing.Metadata) != 0 {
+		t.Errorf("new layer is expected without metadata, got %v (%v)", missing.Metadata, err)
+	}
+}
+
+func TestWithoutProfilerVariables(t *testing.T) {
+	environment := []string{"PATH=/usr/bin", "CORECLR_ENABLE_PROFILING=1", "COR_PROFILER={guid}", "Cor_Enable_Profiling=1", "SL_LOG_LEVEL=6"}
+
+	filtered := withoutProfilerVariables(environment)
+	if !reflect.DeepEqual(filtered, []string{"PATH=/usr/bin", "SL_LOG_LEVEL=6"}) {
+		t.Errorf("unexpected environment %v", filtered)
+	}
+}
+
+func TestCNBDetect(t *testing.T) {
+	cnb := &CNBBuildpack{Log: libbuildpack.NewLogger(&bytes.Buffer{}), BuildpackDir: t.TempDir()}
+
+	root := t.TempDir()
+	t.Setenv(ServiceBindingRootEnvVariable, root)
+	if result := cnb.Detect(); result != CNBDetectFail {
+		t.Errorf("detect is expected to fail without the binding, got %d", result)
+	}
+
+	bindingDir := filepath.Join(root, "sealights")
+	if err := os.Mkdir(bindingDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bindingDir, "type"), []byte(SealightsBindingType), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if result := cnb.Detect(); result != CNBDetectPass {
+		t.Errorf("detect is expected to pass with the binding, got %d", result)
+	}
+}
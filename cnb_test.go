@@ -0,0 +1,85 @@
+package sealights_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sealights "github.com/Sealights/libbuildpack-sealights"
+	"github.com/Sealights/libbuildpack-sealights/sealightstest"
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// Bind the options as the servicebinding.io files and get the buildpack reading them
+func newTestCNBBuildpack(t *testing.T, env *sealightstest.StagingEnvironment, options map[string]string) *sealights.CNBBuildpack {
+	bindingDir := filepath.Join(t.TempDir(), "bindings", "sealights")
+	env.WriteFile(filepath.Join(bindingDir, "type"), sealights.SealightsBindingType)
+	for key, value := range options {
+		env.WriteFile(filepath.Join(bindingDir, key), value)
+	}
+	t.Setenv(sealights.ServiceBindingRootEnvVariable, filepath.Dir(bindingDir))
+
+	return &sealights.CNBBuildpack{Log: env.Log, Command: &libbuildpack.Command{}, BuildpackDir: t.TempDir()}
+}
+
+func TestCNBBuildResolvesPackageOnce(t *testing.T) {
+	skipOnWindows(t)
+
+	server := sealightstest.NewAgentServer(t)
+	env := sealightstest.NewStagingEnvironment(t)
+	buildpack := newTestCNBBuildpack(t, env, map[string]string{
+		"nugetFeed":      server.NugetFeedUrl(),
+		"nugetPackageId": server.NugetPackageId,
+		"tokenFile":      "token.txt",
+	})
+
+	layersDir := t.TempDir()
+	if err := buildpack.Build(layersDir); err != nil {
+		t.Fatal(err)
+	}
+
+	versionRequests := 0
+	for _, request := range server.Requests() {
+		if strings.HasSuffix(request, "/index.json") && strings.Contains(request, "flatcontainer") {
+			versionRequests++
+		}
+	}
+	if versionRequests != 1 {
+		t.Errorf("package versions are expected to be requested once, got %v", server.Requests())
+	}
+
+	metadata := env.ReadFile(filepath.Join(layersDir, sealights.CNBLayerName+".toml"))
+	if !strings.Contains(metadata, `agentVersion = "`+sealightstest.DefaultAgentVersion+`"`) {
+		t.Errorf("unexpected layer metadata:\n%s", metadata)
+	}
+}
+
+func TestCNBBuildRemovesLayerWhenAgentIsNotInstalled(t *testing.T) {
+	server := sealightstest.NewAgentServer(t)
+	env := sealightstest.NewStagingEnvironment(t)
+	buildpack := newTestCNBBuildpack(t, env, map[string]string{
+		"nugetFeed":      server.NugetFeedUrl(),
+		"nugetPackageId": server.NugetPackageId,
+		"version":        "9.9.9",
+		"failurePolicy":  "warn",
+		"tokenFile":      "token.txt",
+	})
+
+	// layer restored from the previous build
+	layersDir := t.TempDir()
+	layerPath := filepath.Join(layersDir, sealights.CNBLayerName)
+	env.WriteFile(filepath.Join(layerPath, sealights.LinuxAgentName), "agent")
+	env.WriteFile(layerPath+".toml", "[types]\nlaunch = true\ncache = true\n")
+
+	if err := buildpack.Build(layersDir); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{layerPath, layerPath + ".toml"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("'%s' is expected to be removed", filepath.Base(path))
+		}
+	}
+	env.AssertLogContains("Integration is degraded (failure policy: warn)")
+}
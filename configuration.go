@@ -3,8 +3,11 @@ package sealights
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/cloudfoundry/libbuildpack"
 )
@@ -14,31 +17,101 @@ type VcapServicesModel struct {
 }
 
 type SealightsOptions struct {
-	Version        string
-	Verb           string
-	CustomAgentUrl string
-	CustomCommand  string
-	Proxy          string
-	ProxyUsername  string
-	ProxyPassword  string
-	UsePic         bool
-	SlArguments    map[string]string
-	SlEnvironment  map[string]string
+	Version             string
+	Verb                string
+	CustomAgentUrl      string
+	CustomCommand       string
+	Proxy               string
+	ProxyUsername       string
+	ProxyPassword       string
+	UsePic              bool
+	UseSidecar          bool
+	UseSupervisor       bool
+	AgentPort           string
+	CollectorUrl        string
+	CollectorId         string
+	ShutdownGracePeriod time.Duration
+	InstanceTag         string
+
+	// BuildSessionVerb enables the build session creation at staging
+	BuildSessionVerb      string
+	BuildSessionArguments map[string]string
+	NugetFeed             string
+	NugetPackageId        string
+	NugetUsername         string
+	NugetPassword         string
+	FailurePolicy         FailurePolicy
+	StagingPhase          string
+	InstrumentationMode   InstrumentationMode
+	SlArguments           map[string]string
+	SlEnvironment         map[string]string
 }
 
+var buildpackSpecificArguments = map[string]bool{
+	"version":             true,
+	"verb":                true,
+	"customAgentUrl":      true,
+	"customCommand":       true,
+	"usePic":              true,
+	"useSidecar":          true,
+	"useSupervisor":       true,
+	"agentPort":           true,
+	"collectorUrl":        true,
+	"collectorId":         true,
+	"shutdownGracePeriod": true,
+	"instanceTag":         true,
+	"buildSessionVerb":    true,
+	"buildSessionCli":     true,
+	"cli":                 true,
+	"env":                 true,
+	"nugetFeed":           true,
+	"nugetPackageId":      true,
+	"nugetUsername":       true,
+	"nugetPassword":       true,
+	"failurePolicy":       true,
+	"stagingPhase":        true,
+	"instrumentationMode": true,
+}
+
+const DefaultToolName = "sl-pcf"
+
 type Configuration struct {
-	Value  *SealightsOptions
-	Log    *libbuildpack.Logger
-	Stager *libbuildpack.Stager
+	Value       *SealightsOptions
+	ServiceName string
+	Log         *libbuildpack.Logger
+	Stager      *libbuildpack.Stager
+
+	// ToolName and BuildpackVersion identify the buildpack for the agent.
+	// Version is taken from the stager if not provided
+	ToolName         string
+	BuildpackVersion string
+
+	// StagingPhase replaces the 'stagingPhase' option if provided, the standalone
+	// supply buildpack can't modify the start command, so it always uses the supply phase
+	StagingPhase string
+	FileSystem   FileSystem
 }
 
 func NewConfiguration(log *libbuildpack.Logger, stager *libbuildpack.Stager) *Configuration {
-	configuration := Configuration{Log: log, Value: nil, Stager: stager}
-	configuration.parseVcapServices()
+	configuration := Configuration{Log: log, Value: nil, Stager: stager, ToolName: DefaultToolName, FileSystem: OsFileSystem{}}
+	configuration.Load()
 
 	return &configuration
 }
 
+// Load reads the options from VCAP_SERVICES, or from the service bindings if the service isn't found there
+func (conf *Configuration) Load() {
+	conf.parseVcapServices()
+
+	// service bindings are used on the kubernetes based platforms instead of VCAP_SERVICES
+	if conf.Value == nil {
+		err := conf.parseServiceBindings(os.Getenv(ServiceBindingRootEnvVariable))
+		if err != nil {
+			conf.Log.Warning("Sealights. Failed to read service bindings: %s", err)
+		}
+	}
+}
+
 func (conf Configuration) UseSealights() bool {
 	return conf.Value != nil
 }
@@ -55,101 +128,250 @@ func (conf *Configuration) parseVcapServices() {
 		return
 	}
 
-	buildpackSpecificArguments := map[string]bool{
-		"version":        true,
-		"verb":           true,
-		"customAgentUrl": true,
-		"customCommand":  true,
-		"usePic":         true,
-		"cli":            true,
-		"env":            true,
-	}
-
 	for _, services := range vcapServices {
 		for _, service := range services {
 			if !strings.Contains(strings.ToLower(service.Name), "sealights") {
 				continue
 			}
 
-			slEnvironment := getMap(service.Credentials, "env")
-			if slEnvironment == nil {
-				slEnvironment = make(map[string]string)
-			}
+			conf.Value = conf.parseCredentials(service.Credentials)
+			conf.ServiceName = service.Name
+			return
+		}
+	}
+}
 
-			slArguments := getMap(service.Credentials, "cli")
-			if slArguments == nil {
-				slArguments = make(map[string]string)
-			}
+// Build options from the service credentials. Credentials could come from
+// VCAP_SERVICES or any other configuration source with the same keys
+func (conf *Configuration) parseCredentials(credentials map[string]interface{}) *SealightsOptions {
+	slEnvironment := getMap(credentials, "env")
+	if slEnvironment == nil {
+		slEnvironment = make(map[string]string)
+	}
 
-			// this validation required to make settings for version 1.5.0 back compatible with 1.4
-			// there is no property "cli" in the old version of the libpack - all fields for cli comes directly from settings
-			// so if env variables are set - all settings not from the new "cli" property will be used only by libpack itself
-			if len(slEnvironment) == 0 {
-				for parameterName, parameterValue := range service.Credentials {
-					_, shouldBeSkipped := buildpackSpecificArguments[parameterName]
-					if shouldBeSkipped {
-						continue
-					}
-
-					slArguments[parameterName] = parameterValue.(string)
-				}
-			} else {
-				conf.Log.Debug("Sealights. Option 'env' is provided - only options specified directly in the 'cli' field will be propagated to a command line")
-			}
+	slArguments := getMap(credentials, "cli")
+	if slArguments == nil {
+		slArguments = make(map[string]string)
+	}
 
-			options := &SealightsOptions{
-				Version:        getValue[string](service.Credentials, "version"),
-				Verb:           getValue[string](service.Credentials, "verb"),
-				CustomAgentUrl: getValue[string](service.Credentials, "customAgentUrl"),
-				CustomCommand:  getValue[string](service.Credentials, "customCommand"),
-				Proxy:          getValue[string](service.Credentials, "proxy"),
-				ProxyUsername:  getValue[string](service.Credentials, "proxyUsername"),
-				ProxyPassword:  getValue[string](service.Credentials, "proxyPassword"),
-				UsePic:         getValue[bool](service.Credentials, "usePic"),
-				SlArguments:    slArguments,
-				SlEnvironment:  slEnvironment,
+	// this validation required to make settings for version 1.5.0 back compatible with 1.4
+	// there is no property "cli" in the old version of the libpack - all fields for cli comes directly from settings
+	// so if env variables are set - all settings not from the new "cli" property will be used only by libpack itself
+	if len(slEnvironment) == 0 {
+		for parameterName, parameterValue := range credentials {
+			_, shouldBeSkipped := buildpackSpecificArguments[parameterName]
+			if shouldBeSkipped {
+				continue
 			}
 
-			// write warning in case token or session is not provided
-			tokenVariables := []string{"token", "tokenFile", "SL_TOKEN", "SL_TOKENFILE"}
-			isTokenProvided := conf.isAnyVariableProvided(tokenVariables, *options)
-			if !isTokenProvided {
-				conf.Log.Warning("The Sealights token has not been provided.")
-			}
+			slArguments[parameterName] = parameterValue.(string)
+		}
+	} else {
+		conf.Log.Debug("Sealights. Option 'env' is provided - only options specified directly in the 'cli' field will be propagated to a command line")
+	}
 
-			_, picEnabled := options.SlEnvironment["SL_PROFILER_INITIALIZECOLLECTOR"]
-			if picEnabled {
-				options.UsePic = true
-			}
+	options := &SealightsOptions{
+		Version:        getValue[string](credentials, "version"),
+		Verb:           getValue[string](credentials, "verb"),
+		CustomAgentUrl: getValue[string](credentials, "customAgentUrl"),
+		CustomCommand:  getValue[string](credentials, "customCommand"),
+		Proxy:          getValue[string](credentials, "proxy"),
+		ProxyUsername:  getValue[string](credentials, "proxyUsername"),
+		ProxyPassword:  getValue[string](credentials, "proxyPassword"),
+		UsePic:         getValue[bool](credentials, "usePic"),
+		UseSidecar:     getValue[bool](credentials, "useSidecar"),
+		UseSupervisor:  getValue[bool](credentials, "useSupervisor"),
+		CollectorUrl:   getValue[string](credentials, "collectorUrl"),
+		CollectorId:    getValue[string](credentials, "collectorId"),
+
+		BuildSessionVerb:      getValue[string](credentials, "buildSessionVerb"),
+		BuildSessionArguments: getMap(credentials, "buildSessionCli"),
+		NugetFeed:             getValue[string](credentials, "nugetFeed"),
+		NugetPackageId:        getValue[string](credentials, "nugetPackageId"),
+		NugetUsername:         getValue[string](credentials, "nugetUsername"),
+		NugetPassword:         getValue[string](credentials, "nugetPassword"),
+		SlArguments:           slArguments,
+		SlEnvironment:         slEnvironment,
+	}
 
-			if options.UsePic {
-				conf.Log.Info("Sealights. PIC mode enabled")
-			}
+	failurePolicy, err := ParseFailurePolicy(getValue[string](credentials, "failurePolicy"))
+	if err != nil {
+		conf.Log.Warning("Sealights. Option 'failurePolicy' is invalid (%s), continue with '%s'", err, failurePolicy)
+	}
+	options.FailurePolicy = failurePolicy
 
-			_, toolsProvided := options.SlArguments["tools"]
-			if !toolsProvided {
-				options.SlArguments["tools"] = conf.buildToolName()
-			}
+	instrumentationMode, err := ParseInstrumentationMode(getValue[string](credentials, "instrumentationMode"))
+	if err != nil {
+		conf.Log.Warning("Sealights. Option 'instrumentationMode' is invalid (%s), continue with '%s'", err, instrumentationMode)
+	}
+	options.InstrumentationMode = instrumentationMode
 
-			_, tagsProvided := options.SlArguments["tags"]
-			if !tagsProvided {
-				options.SlArguments["tags"] = conf.buildToolName()
-			}
+	agentPortValue, agentPortProvided := credentials["agentPort"]
+	if cliPort, cliPortProvided := options.SlArguments[AgentPortArgument]; cliPortProvided {
+		if !agentPortProvided {
+			agentPortValue = cliPort
+		}
+		// the port is passed to the agent from 'agentPort' only, so 'auto' never reaches it
+		delete(options.SlArguments, AgentPortArgument)
+	}
+	options.AgentPort, err = ParseAgentPort(agentPortValue)
+	if err != nil {
+		conf.Log.Warning("Sealights. Option 'agentPort' is invalid (%s), continue with '%s'", err, options.AgentPort)
+	}
 
-			if options.Verb == "" && !options.UsePic {
-				options.Verb = "startBackgroundTestListener"
-				conf.Log.Debug("Sealights. Verb has not been set. Continue with 'startBackgroundTestListener'")
-			}
+	gracePeriodValue, gracePeriodProvided := credentials["shutdownGracePeriod"]
+	options.ShutdownGracePeriod, err = ParseShutdownGracePeriod(gracePeriodValue)
+	if err != nil {
+		conf.Log.Warning("Sealights. Option 'shutdownGracePeriod' is invalid (%s), continue with '%s'", err, options.ShutdownGracePeriod)
+	}
 
-			_, collectorIdPorvided := options.SlArguments["testListenerSessionKey"]
-			if collectorIdPorvided {
-				conf.Log.Warning("Sealights. Option 'testListenerSessionKey' isn't supported in this environment")
-			}
+	options.StagingPhase = strings.ToLower(getValue[string](credentials, "stagingPhase"))
+	if conf.StagingPhase != "" {
+		options.StagingPhase = conf.StagingPhase
+	} else if options.StagingPhase == "" {
+		options.StagingPhase = StagingPhaseFinalize
+	} else if options.StagingPhase != StagingPhaseFinalize && options.StagingPhase != StagingPhaseSupply {
+		conf.Log.Warning("Sealights. Option 'stagingPhase' is invalid ('%s'), continue with '%s'", options.StagingPhase, StagingPhaseFinalize)
+		options.StagingPhase = StagingPhaseFinalize
+	}
 
-			conf.Value = options
-			return
+	// write warning in case token or session is not provided
+	tokenVariables := []string{"token", "tokenFile", "SL_TOKEN", "SL_TOKENFILE"}
+	isTokenProvided := conf.isAnyVariableProvided(tokenVariables, *options)
+	if !isTokenProvided {
+		conf.Log.Warning("The Sealights token has not been provided.")
+	}
+
+	_, picEnabled := options.SlEnvironment["SL_PROFILER_INITIALIZECOLLECTOR"]
+	if picEnabled {
+		options.UsePic = true
+	}
+
+	if options.UsePic {
+		conf.Log.Info("Sealights. PIC mode enabled")
+	}
+
+	_, toolsProvided := options.SlArguments["tools"]
+	if !toolsProvided {
+		options.SlArguments["tools"] = conf.buildToolName()
+	}
+
+	_, tagsProvided := options.SlArguments["tags"]
+	if !tagsProvided {
+		options.SlArguments["tags"] = conf.buildToolName()
+	}
+
+	if options.CollectorUrl != "" {
+		collectorUrl, err := url.Parse(options.CollectorUrl)
+		if err != nil || (collectorUrl.Scheme != "http" && collectorUrl.Scheme != "https") || collectorUrl.Host == "" {
+			conf.Log.Warning("Sealights. Option 'collectorUrl' is invalid ('%s') - the external collector will not be used", options.CollectorUrl)
+			options.CollectorUrl = ""
+		}
+	}
+
+	// session key of the test listener identifies the collector the profiler reports to
+	if options.CollectorId == "" {
+		options.CollectorId = options.SlArguments["testListenerSessionKey"]
+	}
+
+	if instanceTag := getValue[string](credentials, "instanceTag"); instanceTag != "" {
+		conf.addInstanceTag(options, instanceTag)
+	}
+
+	if options.Verb == "" && !options.UsePic && !options.usesExternalCollector() {
+		options.Verb = "startBackgroundTestListener"
+		conf.Log.Debug("Sealights. Verb has not been set. Continue with 'startBackgroundTestListener'")
+	}
+
+	if options.usesExternalCollector() {
+		conf.Log.Info("Sealights. External collector is used (%s)", options.CollectorUrl)
+		if options.Verb == "startBackgroundTestListener" {
+			conf.Log.Warning("Sealights. Background test listener is not started when the external collector is used")
+			options.Verb = ""
+		}
+	}
+
+	if options.UseSidecar && !isSidecarSupported(options) {
+		conf.Log.Warning("Sealights. Option 'useSidecar' is supported only for the background test listener on linux - the sidecar will not be registered")
+		options.UseSidecar = false
+	}
+
+	if options.UseSupervisor && !isSupervisorSupported(options) {
+		conf.Log.Warning("Sealights. Option 'useSupervisor' is supported only for the background test listener started by the start command on linux - the supervisor will not be used")
+		options.UseSupervisor = false
+	}
+
+	if options.UseSupervisor && !isSupervisorAvailable(conf.FileSystem) {
+		conf.Log.Warning("Sealights. Option 'useSupervisor' requires the buildpack executable to be installed as '%s' - the supervisor will not be used", supervisorFileName())
+		options.UseSupervisor = false
+	}
+
+	if options.AgentPort == AgentPortAuto && !options.UseSupervisor {
+		conf.Log.Warning("Sealights. Option 'agentPort' 'auto' requires 'useSupervisor', continue with '%s'", DefaultPort)
+		options.AgentPort = DefaultPort
+	}
+
+	if gracePeriodProvided && !options.UseSupervisor {
+		conf.Log.Warning("Sealights. Option 'shutdownGracePeriod' requires 'useSupervisor' - the option is ignored")
+	}
+
+	if options.BuildSessionVerb != "" {
+		_, idProvided := options.SlArguments["buildSessionId"]
+		_, idFileProvided := options.SlArguments[BuildSessionIdFileArgument]
+
+		if !isBuildSessionVerb(options.BuildSessionVerb) {
+			conf.Log.Warning("Sealights. Option 'buildSessionVerb' is invalid ('%s') - build session will not be created", options.BuildSessionVerb)
+			options.BuildSessionVerb = ""
+		} else if idProvided || idFileProvided {
+			conf.Log.Warning("Sealights. Build session id is provided in 'cli' - build session will not be created")
+			options.BuildSessionVerb = ""
 		}
 	}
+
+	if options.NugetFeed != "" && options.NugetPackageId == "" {
+		conf.Log.Warning("Sealights. Option 'nugetFeed' is provided without 'nugetPackageId' - the feed will not be used")
+		options.NugetFeed = ""
+	}
+
+	return options
+}
+
+// Instance tag is appended to the agent tags, instance fields are expanded at start
+func (conf *Configuration) addInstanceTag(options *SealightsOptions, tagTemplate string) {
+	info, err := NewInstanceInfo()
+	if err == nil {
+		options.InstanceTag, err = info.RenderInstanceTag(tagTemplate)
+	}
+	if err != nil {
+		conf.Log.Warning("Sealights. Option 'instanceTag' is invalid (%s) - instance tag will not be added", err)
+		return
+	}
+
+	options.SlArguments["tags"] = options.SlArguments["tags"] + "," + options.InstanceTag
+}
+
+// Sidecar command is started by the POSIX shell, so windows cells can't run it
+func isSidecarSupported(options *SealightsOptions) bool {
+	return options.Verb == "startBackgroundTestListener" && runtime.GOOS != "windows"
+}
+
+// Supervisor wraps the start command, so it's available only when the listener is started by it
+func isSupervisorSupported(options *SealightsOptions) bool {
+	return options.Verb == "startBackgroundTestListener" && !options.UseSidecar &&
+		options.StagingPhase != StagingPhaseSupply && runtime.GOOS != "windows"
+}
+
+// Profiler reports to the collector running outside of the container instead of the local listener
+func (options *SealightsOptions) usesExternalCollector() bool {
+	return options.CollectorUrl != ""
+}
+
+func (options *SealightsOptions) agentPort() string {
+	if options.AgentPort == "" {
+		return DefaultPort
+	}
+
+	return options.AgentPort
 }
 
 func (conf *Configuration) isAnyVariableProvided(variableName []string, options SealightsOptions) bool {
@@ -169,13 +391,17 @@ func (conf *Configuration) isAnyVariableProvided(variableName []string, options
 }
 
 func (conf *Configuration) buildToolName() string {
-	ver, err := conf.Stager.BuildpackVersion()
-	if err != nil {
-		conf.Log.Warning("Failed to get buildpack version")
-		ver = "unknown"
+	ver := conf.BuildpackVersion
+	if ver == "" {
+		var err error
+		ver, err = conf.Stager.BuildpackVersion()
+		if err != nil {
+			conf.Log.Warning("Failed to get buildpack version")
+			ver = "unknown"
+		}
 	}
 
-	return fmt.Sprintf("sl-pcf-%s", ver)
+	return fmt.Sprintf("%s-%s", conf.ToolName, ver)
 }
 
 func getValue[T any](dict map[string]interface{}, key string) T {
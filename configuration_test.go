@@ -0,0 +1,206 @@
+package sealights
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+func parseTestCredentials(t *testing.T, credentials map[string]interface{}) (*SealightsOptions, string) {
+	t.Helper()
+
+	return parseTestCredentialsInPhase(t, credentials, "")
+}
+
+func parseTestCredentialsInPhase(t *testing.T, credentials map[string]interface{}, stagingPhase string) (*SealightsOptions, string) {
+	t.Helper()
+
+	output := &bytes.Buffer{}
+	conf := &Configuration{
+		Log:              libbuildpack.NewLogger(output),
+		ToolName:         DefaultToolName,
+		BuildpackVersion: "1.0.0",
+		StagingPhase:     stagingPhase,
+		FileSystem:       OsFileSystem{},
+	}
+
+	return conf.parseCredentials(credentials), output.String()
+}
+
+func useTestSupervisorSource(t *testing.T, source string) {
+	original := supervisorSource
+	supervisorSource = func() (string, error) { return source, nil }
+	t.Cleanup(func() { supervisorSource = original })
+}
+
+func TestSupervisorRequiresBuildpackExecutable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("supervisor is supported on linux only")
+	}
+
+	credentials := map[string]interface{}{
+		"verb":          "startBackgroundTestListener",
+		"useSupervisor": true,
+		"cli":           map[string]interface{}{"tokenFile": "token.txt"},
+	}
+
+	source := filepath.Join(t.TempDir(), "finalize")
+	useTestSupervisorSource(t, source)
+
+	options, output := parseTestCredentials(t, credentials)
+	if options.UseSupervisor {
+		t.Error("supervisor must not be used without the binary")
+	}
+	if !strings.Contains(output, "requires the buildpack executable") {
+		t.Errorf("warning is expected, got '%s'", output)
+	}
+
+	if err := os.WriteFile(source, []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if options, _ = parseTestCredentials(t, credentials); !options.UseSupervisor {
+		t.Error("supervisor is expected to be used when the binary is available")
+	}
+}
+
+func TestSupervisorIsIgnoredInSupplyPhase(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "supply")
+	if err := os.WriteFile(source, []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	useTestSupervisorSource(t, source)
+
+	credentials := map[string]interface{}{
+		"verb":          "startBackgroundTestListener",
+		"useSupervisor": true,
+		"stagingPhase":  "finalize",
+		"cli":           map[string]interface{}{"tokenFile": "token.txt"},
+	}
+
+	options, output := parseTestCredentialsInPhase(t, credentials, StagingPhaseSupply)
+	if options.StagingPhase != StagingPhaseSupply {
+		t.Errorf("expected supply phase, got '%s'", options.StagingPhase)
+	}
+	if options.UseSupervisor {
+		t.Error("supervisor must not be used in the supply phase")
+	}
+	if !strings.Contains(output, "'useSupervisor' is supported only") {
+		t.Errorf("warning is expected, got '%s'", output)
+	}
+
+	supplier := &Supplier{Log: libbuildpack.NewLogger(&bytes.Buffer{}), Options: options, AgentDirForRuntime: "/deps/0/sealights", FileSystem: OsFileSystem{}}
+	if _, err := supplier.PlanSupply(); err != nil {
+		t.Errorf("supply is expected to be planned without the supervisor: %v", err)
+	}
+}
+
+func TestSupervisorInvocation(t *testing.T) {
+	tests := map[string]bool{
+		"/home/vcap/app/sealights/sl-supervisor": true,
+		"/tmp/buildpack/bin/finalize":            false,
+		"sl-supervisor.test":                     false,
+	}
+
+	for executable, expected := range tests {
+		if isSupervisorInvocation(executable) != expected {
+			t.Errorf("unexpected supervisor invocation result for '%s'", executable)
+		}
+	}
+}
+
+func TestSidecarIsSupportedOnLinux(t *testing.T) {
+	credentials := map[string]interface{}{
+		"verb":       "startBackgroundTestListener",
+		"useSidecar": true,
+		"cli":        map[string]interface{}{"tokenFile": "token.txt"},
+	}
+
+	options, output := parseTestCredentials(t, credentials)
+	expected := runtime.GOOS != "windows"
+	if options.UseSidecar != expected {
+		t.Errorf("useSidecar is expected to be %v on %s", expected, runtime.GOOS)
+	}
+	if warned := strings.Contains(output, "'useSidecar' is supported only"); warned == expected {
+		t.Errorf("unexpected warnings: '%s'", output)
+	}
+}
+
+func TestAgentPortAutoRequiresSupervisor(t *testing.T) {
+	options, output := parseTestCredentials(t, map[string]interface{}{
+		"verb":      "startBackgroundTestListener",
+		"agentPort": "auto",
+		"cli":       map[string]interface{}{"tokenFile": "token.txt"},
+	})
+
+	if options.UseSupervisor {
+		t.Error("supervisor must not be enabled implicitly")
+	}
+	if options.AgentPort != DefaultPort {
+		t.Errorf("expected default port, got '%s'", options.AgentPort)
+	}
+	if !strings.Contains(output, "requires 'useSupervisor'") {
+		t.Errorf("warning is expected, got '%s'", output)
+	}
+}
+
+func TestShutdownGracePeriodRequiresSupervisor(t *testing.T) {
+	options, output := parseTestCredentials(t, map[string]interface{}{
+		"verb":                "startBackgroundTestListener",
+		"shutdownGracePeriod": "10s",
+		"cli":                 map[string]interface{}{"tokenFile": "token.txt"},
+	})
+
+	if options.UseSupervisor {
+		t.Error("supervisor must not be enabled implicitly")
+	}
+	if !strings.Contains(output, "'shutdownGracePeriod' requires 'useSupervisor'") {
+		t.Errorf("warning is expected, got '%s'", output)
+	}
+}
+
+func TestExternalCollector(t *testing.T) {
+	options, output := parseTestCredentials(t, map[string]interface{}{
+		"verb":                   "startBackgroundTestListener",
+		"collectorUrl":           "https://collector.example.com:8443",
+		"testListenerSessionKey": "session-key",
+		"tokenFile":              "token.txt",
+	})
+
+	if options.Verb != "" {
+		t.Errorf("listener must not be started with the external collector, got verb '%s'", options.Verb)
+	}
+	if options.CollectorId != "session-key" {
+		t.Errorf("collector id is expected to be taken from the session key, got '%s'", options.CollectorId)
+	}
+	if !strings.Contains(output, "Background test listener is not started") {
+		t.Errorf("warning is expected, got '%s'", output)
+	}
+
+	variables := NewEnvManager(libbuildpack.NewLogger(&bytes.Buffer{}), options).GetVariables("/home/vcap/app/sealights")
+	if variables[CollectorUrlEnvVariable] != options.CollectorUrl || variables[CollectorIdEnvVariable] != "session-key" {
+		t.Errorf("collector variables are expected, got %v", variables)
+	}
+	if _, exists := variables[AgentPortEnvVariable]; exists {
+		t.Errorf("port of the local listener is not expected, got %v", variables)
+	}
+}
+
+func TestExternalCollectorInvalidUrl(t *testing.T) {
+	options, output := parseTestCredentials(t, map[string]interface{}{
+		"collectorUrl": "ftp://collector.example.com",
+		"tokenFile":    "token.txt",
+	})
+
+	if options.CollectorUrl != "" || options.Verb != "startBackgroundTestListener" {
+		t.Errorf("local listener is expected for the invalid url, got %+v", options)
+	}
+	if !strings.Contains(output, "Option 'collectorUrl' is invalid") {
+		t.Errorf("warning is expected, got '%s'", output)
+	}
+}
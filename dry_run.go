@@ -0,0 +1,144 @@
+package sealights
+
+import (
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const DryRunEnvVariable = "SL_BUILDPACK_DRY_RUN"
+
+func isDryRun() bool {
+	dryRun, err := strconv.ParseBool(os.Getenv(DryRunEnvVariable))
+	return err == nil && dryRun
+}
+
+// Resolve everything the hook would do and print it, without downloading
+// the agent and without touching the release info
+func (h *SealightsHook) printPlan(conf *Configuration, stager *libbuildpack.Stager) error {
+	h.Log.BeginStep("Sealights. Dry run mode is enabled (%s) - nothing will be changed", DryRunEnvVariable)
+
+	options := conf.Value
+	if err := ExpandOptionTemplates(options); err != nil {
+		h.Log.Error("Sealights. Failed to expand option templates: %v", err)
+	}
+
+	h.Log.Info("Sealights. Options:")
+	h.Log.Info("  verb: %s", options.Verb)
+	h.Log.Info("  customCommand: %s", maskSensitiveData(options.CustomCommand))
+	h.Log.Info("  usePic: %t", options.UsePic)
+	h.Log.Info("  useSidecar: %t", options.UseSidecar)
+	h.Log.Info("  useSupervisor: %t", options.UseSupervisor)
+	h.Log.Info("  agentPort: %s", options.agentPort())
+	h.Log.Info("  collectorUrl: %s", options.CollectorUrl)
+	h.Log.Info("  buildSessionVerb: %s", options.BuildSessionVerb)
+	h.Log.Info("  failurePolicy: %s", options.FailurePolicy)
+	h.Log.Info("  instrumentationMode: %s", options.InstrumentationMode)
+	printVariables(h.Log, "  cli:", maskSensitiveVariables(options.SlArguments))
+	printVariables(h.Log, "  env:", maskSensitiveVariables(options.SlEnvironment))
+
+	agentInstaller := h.newAgentInstaller(options)
+	url, version, err := agentInstaller.ResolvePackage()
+	if err != nil {
+		h.Log.Error("Sealights. Failed to resolve agent package: %v", err)
+	} else {
+		h.Log.Info("Sealights. Agent package: %s (version: %s)", url, version)
+	}
+
+	launcher := h.newLauncher(options, AgentDir, stager)
+	plan, err := launcher.PlanStartParameters(stager)
+	if err != nil {
+		h.Log.Error("Sealights. Failed to plan start parameters: %v", err)
+		return nil
+	}
+
+	if plan.ShouldApply {
+		h.Log.Info("Sealights. Start command would be updated")
+		h.Log.Info("  from: %s", plan.OriginalCommand)
+		h.Log.Info("  to:   %s", maskSensitiveData(plan.StartCommand))
+	} else {
+		h.Log.Info("Sealights. Start command would not be modified")
+	}
+
+	if plan.SidecarCommand != "" {
+		h.Log.Info("Sealights. Background test listener would be started by the sidecar '%s':", SidecarProcessType)
+		h.Log.Info("  %s", maskSensitiveData(plan.SidecarCommand))
+	}
+
+	if plan.ListenerCommand != "" {
+		h.Log.Info("Sealights. Background test listener would be started by profile.d script before '%s':", plan.OriginalCommand)
+		h.Log.Info("  %s", maskSensitiveData(plan.ListenerCommand))
+	}
+
+	if plan.Supervisor != nil {
+		h.Log.Info("Sealights. Background test listener would be started by the supervisor (port: %s, shutdown grace period: %ds)", plan.Supervisor.Port, plan.Supervisor.GracePeriodSeconds)
+	}
+
+	if plan.AgentEnvVariables != nil {
+		printVariables(h.Log, "Sealights. Agent env file "+plan.AgentEnvFile+":", maskSensitiveVariables(plan.AgentEnvVariables))
+	}
+
+	if plan.GlobalEnvFile != "" {
+		printVariables(h.Log, "Sealights. Global env file "+plan.ProfileDFile+":", maskSensitiveVariables(plan.GlobalEnvVariables))
+	} else {
+		printVariables(h.Log, "Sealights. Global env variables:", maskSensitiveVariables(plan.GlobalEnvVariables))
+	}
+
+	return nil
+}
+
+func printVariables(log *libbuildpack.Logger, title string, variables map[string]string) {
+	log.Info("%s", title)
+
+	keys := make([]string, 0, len(variables))
+	for key := range variables {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		log.Info("    %s=%s", key, variables[key])
+	}
+}
+
+// Print the supply phase plan, nothing is downloaded and the dependency directory isn't changed
+func (h *SealightsHook) printSupplyPlan(conf *Configuration, stager *libbuildpack.Stager) error {
+	h.Log.BeginStep("Sealights. Dry run mode is enabled (%s) - nothing will be changed", DryRunEnvVariable)
+
+	if err := ExpandOptionTemplates(conf.Value); err != nil {
+		h.Log.Error("Sealights. Failed to expand option templates: %v", err)
+	}
+
+	agentInstaller := h.newAgentInstaller(conf.Value)
+	url, version, err := agentInstaller.ResolvePackage()
+	if err != nil {
+		h.Log.Error("Sealights. Failed to resolve agent package: %v", err)
+	} else {
+		h.Log.Info("Sealights. Agent package: %s (version: %s)", url, version)
+	}
+
+	supplier := NewSupplier(h.Log, conf.Value, stager)
+	h.Log.Info("Sealights. Agent would be installed into %s", supplier.AgentDirAbsolute)
+
+	plan, err := supplier.PlanSupply()
+	if err != nil {
+		h.Log.Error("Sealights. Failed to plan supply: %v", err)
+		return nil
+	}
+
+	if plan.ListenerCommand != "" {
+		h.Log.Info("Sealights. Background test listener would be started by profile.d script:")
+		h.Log.Info("  %s", maskSensitiveData(plan.ListenerCommand))
+	}
+
+	if plan.SidecarCommand != "" {
+		h.Log.Info("Sealights. Background test listener would be started by the sidecar '%s':", SidecarProcessType)
+		h.Log.Info("  %s", maskSensitiveData(plan.SidecarCommand))
+	}
+
+	printVariables(h.Log, "Sealights. Profile.d script "+plan.ProfileDScript+":", maskSensitiveVariables(plan.Variables))
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package sealights
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+func TestIsDryRun(t *testing.T) {
+	tests := map[string]bool{"true": true, "1": true, "false": false, "": false, "yes": false}
+	for value, expected := range tests {
+		t.Setenv(DryRunEnvVariable, value)
+		if isDryRun() != expected {
+			t.Errorf("'%s': expected dry run %t", value, expected)
+		}
+	}
+}
+
+func TestPrintPlanKeepsApplicationUnchanged(t *testing.T) {
+	buildDir := t.TempDir()
+	releaseFile := filepath.Join(buildDir, "tmp", ReleaseFileName)
+	release := "default_process_types:\n  web: cd ${DEPS_DIR}/0/dotnet_publish && exec ./app\n"
+	if err := os.MkdirAll(filepath.Dir(releaseFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(releaseFile, []byte(release), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := &bytes.Buffer{}
+	log := libbuildpack.NewLogger(output)
+	stager := libbuildpack.NewStager([]string{buildDir, t.TempDir(), t.TempDir(), "0"}, log, nil)
+	conf := &Configuration{Log: log, Stager: stager, Value: &SealightsOptions{
+		Verb:           "startBackgroundTestListener",
+		CustomAgentUrl: "https://agents.example.com/agent.tar.gz",
+		SlArguments:    map[string]string{"token": "secret-token"},
+		SlEnvironment:  map[string]string{},
+	}}
+
+	hook := &SealightsHook{Log: log, FileSystem: OsFileSystem{}}
+	if err := hook.printPlan(conf, stager); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(output.String(), "Start command would be updated") {
+		t.Errorf("start command is expected in the plan:\n%s", output.String())
+	}
+	if strings.Contains(output.String(), "secret-token") {
+		t.Errorf("token is expected to be masked:\n%s", output.String())
+	}
+
+	if content, _ := os.ReadFile(releaseFile); string(content) != release {
+		t.Errorf("release file must not be changed:\n%s", content)
+	}
+	if _, err := os.Stat(filepath.Join(buildDir, AgentDir)); !os.IsNotExist(err) {
+		t.Error("agent must not be installed")
+	}
+}
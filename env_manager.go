@@ -2,9 +2,9 @@ package sealights
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 
 	"github.com/cloudfoundry/libbuildpack"
 )
@@ -18,6 +18,9 @@ const LinuxProfilerName = "libSL.DotNet.ProfilerLib.Linux.so"
 
 const DefaultPort = "31031"
 
+const CollectorIdEnvVariable = "SL_CollectorId"
+const CollectorUrlEnvVariable = "SL_CollectorUrl"
+
 type PlatformProfilerParams struct {
 	Name_32 string
 	Name_64 string
@@ -25,38 +28,47 @@ type PlatformProfilerParams struct {
 }
 
 type EnvManager struct {
-	Options *SealightsOptions
-	Log     *libbuildpack.Logger
+	Options    *SealightsOptions
+	Log        *libbuildpack.Logger
+	FileSystem FileSystem
+
+	// App is the detected target application, profiler variables are not set if it can't be instrumented
+	App *AppInfo
 }
 
 func NewEnvManager(log *libbuildpack.Logger, options *SealightsOptions) *EnvManager {
-	envManager := EnvManager{Log: log, Options: options}
+	envManager := EnvManager{Log: log, Options: options, FileSystem: OsFileSystem{}}
 
 	return &envManager
 }
 
 func (emng *EnvManager) GetVariables(runtimeDirectory string) map[string]string {
-	profilerInfo := emng.getProfilerInfo()
-
-	agentProfilerLibx86 := filepath.Join(runtimeDirectory, profilerInfo.Name_32)
-	agentProfilerLibx64 := filepath.Join(runtimeDirectory, profilerInfo.Name_64)
-
 	env_variables := map[string]string{}
 
-	env_variables["Cor_Profiler"] = profilerInfo.Id
-	env_variables["Cor_Enable_Profiling"] = "1"
-	env_variables["Cor_Profiler_Path"] = agentProfilerLibx64
-	env_variables["COR_PROFILER_PATH_32"] = agentProfilerLibx86
-	env_variables["COR_PROFILER_PATH_64"] = agentProfilerLibx64
-	env_variables["CORECLR_ENABLE_PROFILING"] = "1"
-	env_variables["CORECLR_PROFILER"] = profilerInfo.Id
-	env_variables["CORECLR_PROFILER_PATH_32"] = agentProfilerLibx86
-	env_variables["CORECLR_PROFILER_PATH_64"] = agentProfilerLibx64
-	env_variables["SL_AGENT_PORT"] = DefaultPort
+	switch emng.Options.InstrumentationMode {
+	case InstrumentationModeStartupHook:
+		if emng.App != nil && !emng.App.StartupHooksSupported {
+			// trimmed applications ignore startup hooks, the profiler still works for them
+			emng.addProfilerVariables(env_variables, runtimeDirectory)
+			break
+		}
+		env_variables[StartupHooksEnvVariable] = filepath.Join(runtimeDirectory, StartupHookAssemblyName)
+	case InstrumentationModeNone:
+		// only the agent is started, the application isn't instrumented
+	default:
+		emng.addProfilerVariables(env_variables, runtimeDirectory)
+	}
+
+	// profiler connects to the external collector or to the local listener,
+	// in the auto mode the port is selected and exported by the supervisor
+	if emng.Options.usesExternalCollector() {
+		env_variables[CollectorUrlEnvVariable] = emng.Options.CollectorUrl
+	} else if agentPort := emng.Options.agentPort(); agentPort != AgentPortAuto {
+		env_variables[AgentPortEnvVariable] = agentPort
+	}
 
-	testListenerSessionKey, sessionKeyExists := emng.Options.SlArguments["testListenerSessionKey"]
-	if sessionKeyExists {
-		env_variables["SL_CollectorId"] = testListenerSessionKey
+	if emng.Options.CollectorId != "" {
+		env_variables[CollectorIdEnvVariable] = emng.Options.CollectorId
 	}
 
 	if emng.Options.UsePic {
@@ -64,6 +76,23 @@ func (emng *EnvManager) GetVariables(runtimeDirectory string) map[string]string
 		env_variables["SL_PROFILER_BLOCKING_CONNECTION_STARTUP"] = "ASYNC"
 	}
 
+	if emng.App != nil && !emng.App.IsInstrumentable() {
+		for key := range env_variables {
+			if isProfilerVariable(key) || key == StartupHooksEnvVariable {
+				delete(env_variables, key)
+			}
+		}
+	}
+
+	// full framework loads the profiler configured by COR_* variables only
+	if emng.App != nil && emng.App.Kind == AppKindNetFramework {
+		for key := range env_variables {
+			if strings.HasPrefix(strings.ToUpper(key), "CORECLR_") {
+				delete(env_variables, key)
+			}
+		}
+	}
+
 	// put to the dictionary provided variables and
 	// replace auto generated variables with provided
 	for key, value := range emng.Options.SlEnvironment {
@@ -74,30 +103,75 @@ func (emng *EnvManager) GetVariables(runtimeDirectory string) map[string]string
 }
 
 func (emng *EnvManager) WriteIntoFile(filePath string, envVariables map[string]string) error {
-	exportCommand := "export"
-	if runtime.GOOS == "windows" {
-		exportCommand = "set"
-	}
+	fileContent := emng.FormatVariables(envVariables)
 
-	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	err := emng.FileSystem.AppendFile(filePath, []byte(fileContent), 0644)
 	if err != nil {
 		emng.Log.Error(fmt.Sprint(err))
 		return err
 	}
 
-	defer file.Close()
+	return nil
+}
+
+// FormatVariables returns script content that exports the variables
+func (emng *EnvManager) FormatVariables(envVariables map[string]string) string {
+	exportCommand := "export"
+	if runtime.GOOS == "windows" {
+		exportCommand = "set"
+	}
 
 	fileContent := ""
 
 	for key, value := range envVariables {
+		if key == StartupHooksEnvVariable {
+			fileContent += formatStartupHooks(value)
+			continue
+		}
+
 		fileContent += fmt.Sprintf("%s %s=%s\n", exportCommand, key, value)
 	}
 
-	if _, err = file.WriteString(fileContent); err != nil {
-		return err
+	return fileContent
+}
+
+// Startup hooks of the application or other tools are kept, the hook is appended
+// with the platform path separator. The script could be executed several times
+// (profile.d and the start command), so the hook isn't added twice
+func formatStartupHooks(hook string) string {
+	if runtime.GOOS == "windows" {
+		return fmt.Sprintf("if defined %[1]s (set \"%[1]s=%%%[1]s%%;%[2]s\") else (set \"%[1]s=%[2]s\")\n", StartupHooksEnvVariable, hook)
 	}
 
-	return nil
+	return fmt.Sprintf("case \":${%[1]s}:\" in *\":%[2]s:\"*) ;; *) export %[1]s=\"${%[1]s:+${%[1]s}:}%[2]s\" ;; esac\n", StartupHooksEnvVariable, hook)
+}
+
+func (emng *EnvManager) addProfilerVariables(env_variables map[string]string, runtimeDirectory string) {
+	profilerInfo := emng.getProfilerInfo()
+
+	agentProfilerLibx86 := filepath.Join(runtimeDirectory, profilerInfo.Name_32)
+	agentProfilerLibx64 := filepath.Join(runtimeDirectory, profilerInfo.Name_64)
+
+	env_variables["Cor_Profiler"] = profilerInfo.Id
+	env_variables["Cor_Enable_Profiling"] = "1"
+	env_variables["Cor_Profiler_Path"] = agentProfilerLibx64
+	env_variables["COR_PROFILER_PATH_32"] = agentProfilerLibx86
+	env_variables["COR_PROFILER_PATH_64"] = agentProfilerLibx64
+	env_variables["CORECLR_ENABLE_PROFILING"] = "1"
+	env_variables["CORECLR_PROFILER"] = profilerInfo.Id
+	env_variables["CORECLR_PROFILER_PATH_32"] = agentProfilerLibx86
+	env_variables["CORECLR_PROFILER_PATH_64"] = agentProfilerLibx64
+}
+
+// Variables set by addProfilerVariables
+var profilerVariables = []string{
+	"Cor_Profiler", "Cor_Enable_Profiling", "Cor_Profiler_Path", "COR_PROFILER_PATH_32", "COR_PROFILER_PATH_64",
+	"CORECLR_ENABLE_PROFILING", "CORECLR_PROFILER", "CORECLR_PROFILER_PATH_32", "CORECLR_PROFILER_PATH_64",
+}
+
+func isProfilerVariable(name string) bool {
+	name = strings.ToUpper(name)
+	return strings.HasPrefix(name, "CORECLR_") || strings.HasPrefix(name, "COR_")
 }
 
 func (emng *EnvManager) getProfilerInfo() *PlatformProfilerParams {
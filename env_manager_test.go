@@ -0,0 +1,43 @@
+package sealights
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+func TestFormatVariablesAppendsStartupHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script is tested on linux")
+	}
+
+	envManager := NewEnvManager(libbuildpack.NewLogger(os.Stdout), &SealightsOptions{})
+	script := envManager.FormatVariables(map[string]string{StartupHooksEnvVariable: "/home/vcap/app/sealights/hook.dll"})
+
+	tests := []struct {
+		existing string
+		expected string
+	}{
+		{"", "/home/vcap/app/sealights/hook.dll"},
+		{"/app/other.dll", "/app/other.dll:/home/vcap/app/sealights/hook.dll"},
+		{"/app/other.dll:/home/vcap/app/sealights/hook.dll", "/app/other.dll:/home/vcap/app/sealights/hook.dll"},
+	}
+
+	for _, test := range tests {
+		// the script is executed twice, like profile.d and the env file of the start command
+		cmd := exec.Command("sh", "-c", script+script+"printf %s \"$"+StartupHooksEnvVariable+"\"")
+		cmd.Env = []string{StartupHooksEnvVariable + "=" + test.existing}
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result := strings.TrimSpace(string(output)); result != test.expected {
+			t.Errorf("'%s': expected '%s', got '%s'", test.existing, test.expected, result)
+		}
+	}
+}
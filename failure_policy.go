@@ -0,0 +1,138 @@
+package sealights
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+type FailurePolicy string
+
+const (
+	// FailurePolicyFail stops the staging on the first failed step
+	FailurePolicyFail FailurePolicy = "fail"
+	// FailurePolicyWarn reports the failed step and continues with the rest of the steps
+	FailurePolicyWarn FailurePolicy = "warn"
+	// FailurePolicySkip reports the failed step and skips all the remaining Sealights steps
+	FailurePolicySkip FailurePolicy = "skip"
+)
+
+const DefaultFailurePolicy = FailurePolicyFail
+
+// Names of the staging steps, the failure policy is applied to each of them
+const (
+	StepExpandTemplates       = "expand option templates"
+	StepInstallAgent          = "install agent"
+	StepCreateBuildSession    = "create build session"
+	StepModifyStartParameters = "modify start parameters"
+	StepWriteStagingReport    = "write staging report"
+
+	StepPlanStartParameters = "plan start parameters"
+	StepWriteAgentEnvFile   = "write agent env file"
+	StepSetEnvVariables     = "set env variables globally"
+	StepInstallSupervisor   = "install supervisor"
+	StepRegisterSidecar     = "register sidecar"
+	StepUpdateStartCommand  = "update start command"
+
+	StepPlanSupply        = "plan supply"
+	StepWriteProfileD     = "write profile.d script"
+	StepLinkAgentBinary   = "link agent binary"
+	StepWriteSupplyEnvDir = "write env directory"
+
+	StepWriteLaunchEnv    = "write launch env"
+	StepConfigureListener = "configure background test listener"
+	StepWriteLayer        = "write layer metadata"
+)
+
+// StepError is returned for the failed step when the failure policy requires to stop the staging
+type StepError struct {
+	Step string
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("sealights: %s: %v", e.Step, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+type DegradedStep struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// StagingSteps runs Sealights staging steps and applies the failure policy to their errors.
+// Failed and not executed steps are collected to be reported in the end of the staging
+type StagingSteps struct {
+	Log      *libbuildpack.Logger
+	Policy   FailurePolicy
+	Degraded []DegradedStep
+	stopped  bool
+}
+
+func NewStagingSteps(log *libbuildpack.Logger, policy FailurePolicy) *StagingSteps {
+	return &StagingSteps{Log: log, Policy: policy}
+}
+
+func ParseFailurePolicy(value string) (FailurePolicy, error) {
+	switch policy := FailurePolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case "":
+		return DefaultFailurePolicy, nil
+	case FailurePolicyFail, FailurePolicyWarn, FailurePolicySkip:
+		return policy, nil
+	default:
+		return DefaultFailurePolicy, fmt.Errorf("unknown failure policy '%s'", value)
+	}
+}
+
+// Run executes the step. Returns true if the step succeeded. Error is returned
+// only if the step failed and the policy requires to stop the staging
+func (st *StagingSteps) Run(name string, step func() error) (bool, error) {
+	if st.stopped {
+		st.Skip(name, "skipped after the previous failure")
+		return false, nil
+	}
+
+	err := step()
+	if err == nil {
+		return true, nil
+	}
+
+	switch st.Policy {
+	case FailurePolicyWarn:
+		st.Log.Warning("Sealights. Step '%s' failed: %v", name, err)
+	case FailurePolicySkip:
+		st.Log.Warning("Sealights. Step '%s' failed, the rest of Sealights steps will be skipped: %v", name, err)
+		st.stopped = true
+	default:
+		st.Log.Error("Sealights. Step '%s' failed: %v", name, err)
+		return false, &StepError{Step: name, Err: err}
+	}
+
+	st.Degraded = append(st.Degraded, DegradedStep{Name: name, Reason: err.Error()})
+	return false, nil
+}
+
+// Skip marks the step as not executed
+func (st *StagingSteps) Skip(name string, reason string) {
+	st.Log.Debug("Sealights. Step '%s' is skipped: %s", name, reason)
+	st.Degraded = append(st.Degraded, DegradedStep{Name: name, Reason: reason})
+}
+
+func (st *StagingSteps) IsDegraded() bool {
+	return len(st.Degraded) > 0
+}
+
+func (st *StagingSteps) PrintSummary() {
+	if !st.IsDegraded() {
+		return
+	}
+
+	st.Log.Warning("Sealights. Integration is degraded (failure policy: %s). Steps not completed:", st.Policy)
+	for _, step := range st.Degraded {
+		st.Log.Warning("  %s: %s", step.Name, step.Reason)
+	}
+}
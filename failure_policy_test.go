@@ -0,0 +1,69 @@
+package sealights
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+func TestParseFailurePolicy(t *testing.T) {
+	tests := map[string]FailurePolicy{
+		"":       FailurePolicyFail,
+		"fail":   FailurePolicyFail,
+		" Warn ": FailurePolicyWarn,
+		"SKIP":   FailurePolicySkip,
+	}
+	for value, expected := range tests {
+		policy, err := ParseFailurePolicy(value)
+		if err != nil || policy != expected {
+			t.Errorf("'%s': expected '%s', got '%s' (%v)", value, expected, policy, err)
+		}
+	}
+
+	if policy, err := ParseFailurePolicy("ignore"); err == nil || policy != DefaultFailurePolicy {
+		t.Errorf("unknown policy is expected to fall back to the default with an error, got '%s' (%v)", policy, err)
+	}
+}
+
+func TestStagingStepsApplyPolicy(t *testing.T) {
+	failed := func() error { return errors.New("failed") }
+	succeeded := func() error { return nil }
+
+	tests := []struct {
+		policy           FailurePolicy
+		expectError      bool
+		expectLastStep   bool
+		expectedDegraded int
+	}{
+		{FailurePolicyFail, true, false, 0},
+		{FailurePolicyWarn, false, true, 1},
+		{FailurePolicySkip, false, false, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.policy), func(t *testing.T) {
+			steps := NewStagingSteps(libbuildpack.NewLogger(&bytes.Buffer{}), test.policy)
+
+			_, err := steps.Run(StepInstallAgent, failed)
+			if test.expectError != (err != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err != nil {
+				return
+			}
+
+			lastStepDone, err := steps.Run(StepUpdateStartCommand, succeeded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lastStepDone != test.expectLastStep {
+				t.Errorf("expected the next step to be executed: %t", test.expectLastStep)
+			}
+			if len(steps.Degraded) != test.expectedDegraded {
+				t.Errorf("expected %d degraded steps, got %+v", test.expectedDegraded, steps.Degraded)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package sealights
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// HTTPClient is an interface around http.Client, so downloads could be replaced by fakes in the unit tests
+type HTTPClient interface {
+	Do(request *http.Request) (*http.Response, error)
+}
+
+// FileSystem represents file operations of the agent installer, archive extractor, launcher,
+// env manager and the files written at staging (release info, staging report, sidecar).
+// We have it as an interface so that we can mock it and use in the unit tests.
+type FileSystem interface {
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, mode fs.FileMode) error
+	AppendFile(name string, data []byte, mode fs.FileMode) error
+	Open(name string) (io.ReadCloser, error)
+	// Create truncates the file or creates it together with the parent directories
+	Create(name string, mode fs.FileMode) (io.WriteCloser, error)
+	MkdirAll(path string, mode fs.FileMode) error
+	MkdirTemp(dir string, pattern string) (string, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Remove(name string) error
+	RemoveAll(path string) error
+	Rename(oldPath string, newPath string) error
+	Symlink(oldName string, newName string) error
+	Link(oldName string, newName string) error
+	EvalSymlinks(path string) (string, error)
+	Chmod(name string, mode fs.FileMode) error
+	WalkDir(root string, fn fs.WalkDirFunc) error
+}
+
+// OsFileSystem is the FileSystem of the host
+type OsFileSystem struct{}
+
+func (OsFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OsFileSystem) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (OsFileSystem) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (OsFileSystem) WriteFile(name string, data []byte, mode fs.FileMode) error {
+	return os.WriteFile(name, data, mode)
+}
+
+func (OsFileSystem) AppendFile(name string, data []byte, mode fs.FileMode) error {
+	fh, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err = fh.Write(data); err != nil {
+		fh.Close()
+		return err
+	}
+
+	return fh.Close()
+}
+
+func (OsFileSystem) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (OsFileSystem) Create(name string, mode fs.FileMode) (io.WriteCloser, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+}
+
+func (OsFileSystem) MkdirAll(path string, mode fs.FileMode) error {
+	return os.MkdirAll(path, mode)
+}
+
+func (OsFileSystem) MkdirTemp(dir string, pattern string) (string, error) {
+	return os.MkdirTemp(dir, pattern)
+}
+
+func (OsFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (OsFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OsFileSystem) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (OsFileSystem) Rename(oldPath string, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+func (OsFileSystem) Symlink(oldName string, newName string) error {
+	return os.Symlink(oldName, newName)
+}
+
+func (OsFileSystem) Link(oldName string, newName string) error {
+	return os.Link(oldName, newName)
+}
+
+func (OsFileSystem) EvalSymlinks(path string) (string, error) {
+	return filepath.EvalSymlinks(path)
+}
+
+func (OsFileSystem) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (OsFileSystem) WalkDir(root string, fn fs.WalkDirFunc) error {
+	return filepath.WalkDir(root, fn)
+}
+
+func fileExists(fileSystem FileSystem, name string) (bool, error) {
+	if _, err := fileSystem.Stat(name); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Copy the reader into the file, the parent directories are created
+func writeFile(fileSystem FileSystem, source io.Reader, destFile string, mode fs.FileMode) error {
+	fh, err := fileSystem.Create(destFile, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(fh, source); err != nil {
+		fh.Close()
+		return err
+	}
+
+	return fh.Close()
+}
+
+// Copy the file keeping its mode, the parent directories of the destination are created
+func copyFile(fileSystem FileSystem, source string, destFile string) error {
+	info, err := fileSystem.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	fh, err := fileSystem.Open(source)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	return writeFile(fileSystem, fh, destFile, info.Mode())
+}
+
+// Move content of the source directory into the destination one, like libbuildpack.MoveDirectory:
+// existing directories are merged, existing files are kept
+func moveDirectory(fileSystem FileSystem, srcDir string, destDir string) error {
+	destExists, err := fileExists(fileSystem, destDir)
+	if err != nil {
+		return err
+	}
+	if !destExists {
+		return fileSystem.Rename(srcDir, destDir)
+	}
+
+	entries, err := fileSystem.ReadDir(srcDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		src := filepath.Join(srcDir, entry.Name())
+		dest := filepath.Join(destDir, entry.Name())
+
+		if _, err := fileSystem.Lstat(dest); err == nil {
+			if entry.IsDir() {
+				if err = moveDirectory(fileSystem, src, dest); err != nil {
+					return err
+				}
+			}
+			continue
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		if err = fileSystem.Rename(src, dest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readYaml(fileSystem FileSystem, filePath string, value interface{}) error {
+	data, err := fileSystem.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal(data, value)
+}
+
+func writeYaml(fileSystem FileSystem, filePath string, value interface{}) error {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(fileSystem, bytes.NewReader(data), filePath, 0644)
+}
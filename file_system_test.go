@@ -0,0 +1,568 @@
+package sealights
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+type fakeFile struct {
+	data []byte
+	mode fs.FileMode
+	link string
+}
+
+// fakeFileSystem keeps files in memory, paths are absolute slash separated
+type fakeFileSystem struct {
+	files    map[string]*fakeFile
+	tempDirs int
+}
+
+func newFakeFileSystem() *fakeFileSystem {
+	return &fakeFileSystem{files: map[string]*fakeFile{"/": {mode: fs.ModeDir | 0755}}}
+}
+
+// Resolve symlinks of the path components, the last one is resolved only if requested
+func (f *fakeFileSystem) resolve(name string, followLast bool) (string, error) {
+	parts := strings.Split(filepath.Clean(name), "/")
+	resolved := "/"
+	for hops := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		file, found := f.files[next]
+		if !found || file.mode&fs.ModeSymlink == 0 || (len(parts) == 0 && !followLast) {
+			resolved = next
+			continue
+		}
+
+		if hops++; hops > 40 {
+			return "", &fs.PathError{Op: "resolve", Path: name, Err: errors.New("too many links")}
+		}
+		if filepath.IsAbs(file.link) {
+			resolved = "/"
+		}
+		parts = append(strings.Split(file.link, "/"), parts...)
+	}
+
+	return resolved, nil
+}
+
+func (f *fakeFileSystem) lookup(op string, name string, followLast bool) (string, *fakeFile, error) {
+	resolved, err := f.resolve(name, followLast)
+	if err != nil {
+		return "", nil, err
+	}
+
+	file, found := f.files[resolved]
+	if !found {
+		return resolved, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return resolved, file, nil
+}
+
+func (f *fakeFileSystem) Stat(name string) (fs.FileInfo, error) {
+	resolved, file, err := f.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return fakeFileInfo{name: filepath.Base(resolved), file: file}, nil
+}
+
+func (f *fakeFileSystem) Lstat(name string) (fs.FileInfo, error) {
+	resolved, file, err := f.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return fakeFileInfo{name: filepath.Base(resolved), file: file}, nil
+}
+
+func (f *fakeFileSystem) ReadFile(name string) ([]byte, error) {
+	_, file, err := f.lookup("read", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if file.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+
+	return append([]byte(nil), file.data...), nil
+}
+
+func (f *fakeFileSystem) WriteFile(name string, data []byte, mode fs.FileMode) error {
+	resolved, err := f.resolve(name, true)
+	if err != nil {
+		return err
+	}
+	if err = f.MkdirAll(filepath.Dir(resolved), 0755); err != nil {
+		return err
+	}
+
+	if file, found := f.files[resolved]; found {
+		file.data = append([]byte(nil), data...)
+		return nil
+	}
+
+	f.files[resolved] = &fakeFile{data: append([]byte(nil), data...), mode: mode.Perm()}
+	return nil
+}
+
+func (f *fakeFileSystem) AppendFile(name string, data []byte, mode fs.FileMode) error {
+	existing, err := f.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return f.WriteFile(name, append(existing, data...), mode)
+}
+
+func (f *fakeFileSystem) Open(name string) (io.ReadCloser, error) {
+	data, err := f.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (f *fakeFileSystem) Create(name string, mode fs.FileMode) (io.WriteCloser, error) {
+	if err := f.WriteFile(name, nil, mode); err != nil {
+		return nil, err
+	}
+
+	return &fakeWriter{fileSystem: f, name: name}, nil
+}
+
+func (f *fakeFileSystem) MkdirAll(path string, mode fs.FileMode) error {
+	resolved, err := f.resolve(path, true)
+	if err != nil {
+		return err
+	}
+
+	for dir := resolved; dir != "/"; dir = filepath.Dir(dir) {
+		if file, found := f.files[dir]; found {
+			if !file.mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: dir, Err: errors.New("not a directory")}
+			}
+			continue
+		}
+
+		f.files[dir] = &fakeFile{mode: fs.ModeDir | mode.Perm()}
+	}
+
+	return nil
+}
+
+func (f *fakeFileSystem) MkdirTemp(dir string, pattern string) (string, error) {
+	if dir == "" {
+		dir = "/tmp"
+	}
+
+	f.tempDirs++
+	name := filepath.Join(dir, fmt.Sprintf("%s%d", pattern, f.tempDirs))
+	return name, f.MkdirAll(name, 0700)
+}
+
+func (f *fakeFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	resolved, file, err := f.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !file.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	var entries []fs.DirEntry
+	for _, child := range f.children(resolved) {
+		entries = append(entries, fs.FileInfoToDirEntry(fakeFileInfo{name: filepath.Base(child), file: f.files[child]}))
+	}
+
+	return entries, nil
+}
+
+func (f *fakeFileSystem) Remove(name string) error {
+	resolved, file, err := f.lookup("remove", name, false)
+	if err != nil {
+		return err
+	}
+	if file.mode.IsDir() && len(f.children(resolved)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+	}
+
+	delete(f.files, resolved)
+	return nil
+}
+
+func (f *fakeFileSystem) RemoveAll(path string) error {
+	resolved, err := f.resolve(path, false)
+	if err != nil {
+		return err
+	}
+
+	for name := range f.files {
+		if name == resolved || strings.HasPrefix(name, resolved+"/") {
+			delete(f.files, name)
+		}
+	}
+
+	return nil
+}
+
+func (f *fakeFileSystem) Rename(oldPath string, newPath string) error {
+	source, _, err := f.lookup("rename", oldPath, false)
+	if err != nil {
+		return err
+	}
+	destination, err := f.resolve(newPath, false)
+	if err != nil {
+		return err
+	}
+
+	for name, file := range f.files {
+		if name == source || strings.HasPrefix(name, source+"/") {
+			delete(f.files, name)
+			f.files[destination+strings.TrimPrefix(name, source)] = file
+		}
+	}
+
+	return nil
+}
+
+func (f *fakeFileSystem) Symlink(oldName string, newName string) error {
+	resolved, err := f.resolve(newName, false)
+	if err != nil {
+		return err
+	}
+	if _, found := f.files[resolved]; found {
+		return &fs.PathError{Op: "symlink", Path: newName, Err: fs.ErrExist}
+	}
+
+	f.files[resolved] = &fakeFile{mode: fs.ModeSymlink | 0777, link: oldName}
+	return nil
+}
+
+func (f *fakeFileSystem) Link(oldName string, newName string) error {
+	_, file, err := f.lookup("link", oldName, false)
+	if err != nil {
+		return err
+	}
+	resolved, err := f.resolve(newName, false)
+	if err != nil {
+		return err
+	}
+
+	f.files[resolved] = file
+	return nil
+}
+
+func (f *fakeFileSystem) EvalSymlinks(path string) (string, error) {
+	resolved, _, err := f.lookup("evalsymlinks", path, true)
+	return resolved, err
+}
+
+func (f *fakeFileSystem) Chmod(name string, mode fs.FileMode) error {
+	_, file, err := f.lookup("chmod", name, true)
+	if err != nil {
+		return err
+	}
+
+	file.mode = file.mode&fs.ModeType | mode.Perm()
+	return nil
+}
+
+func (f *fakeFileSystem) WalkDir(root string, fn fs.WalkDirFunc) error {
+	root = filepath.Clean(root)
+	info, err := f.Lstat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+
+	err = f.walk(root, fs.FileInfoToDirEntry(info), fn)
+	if err == fs.SkipDir {
+		return nil
+	}
+
+	return err
+}
+
+func (f *fakeFileSystem) walk(path string, entry fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, entry, nil); err != nil || !entry.IsDir() {
+		return err
+	}
+
+	for _, child := range f.children(path) {
+		info := fakeFileInfo{name: filepath.Base(child), file: f.files[child]}
+		if err := f.walk(child, fs.FileInfoToDirEntry(info), fn); err != nil && err != fs.SkipDir {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *fakeFileSystem) children(dir string) []string {
+	var children []string
+	for name := range f.files {
+		if name != dir && filepath.Dir(name) == dir {
+			children = append(children, name)
+		}
+	}
+	sort.Strings(children)
+
+	return children
+}
+
+type fakeWriter struct {
+	fileSystem *fakeFileSystem
+	name       string
+	buffer     bytes.Buffer
+}
+
+func (w *fakeWriter) Write(p []byte) (int, error) {
+	return w.buffer.Write(p)
+}
+
+func (w *fakeWriter) Close() error {
+	return w.fileSystem.WriteFile(w.name, w.buffer.Bytes(), 0)
+}
+
+type fakeFileInfo struct {
+	name string
+	file *fakeFile
+}
+
+func (fi fakeFileInfo) Name() string       { return fi.name }
+func (fi fakeFileInfo) Size() int64        { return int64(len(fi.file.data)) }
+func (fi fakeFileInfo) Mode() fs.FileMode  { return fi.file.mode }
+func (fi fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fakeFileInfo) IsDir() bool        { return fi.file.mode.IsDir() }
+func (fi fakeFileInfo) Sys() interface{}   { return nil }
+
+// fakeHTTPClient serves the content by the request url
+type fakeHTTPClient struct {
+	content  map[string][]byte
+	requests []string
+}
+
+func (c *fakeHTTPClient) Do(request *http.Request) (*http.Response, error) {
+	c.requests = append(c.requests, request.URL.String())
+
+	data, found := c.content[request.URL.String()]
+	if !found {
+		return &http.Response{StatusCode: http.StatusNotFound, Request: request, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}
+
+	return &http.Response{StatusCode: http.StatusOK, Request: request, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func TestInstallAgentWithFakes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake file system uses slash separated paths")
+	}
+
+	agentFiles := []testArchiveEntry{
+		{Name: "version.txt", Content: "1.2.3\n"},
+		{Name: "SL.DotNet", Content: "\x7fELF agent"},
+		{Name: "lib/" + LinuxProfilerName, Content: "profiler"},
+		{Name: LinuxProfilerName, Type: tar.TypeSymlink, Linkname: "lib/" + LinuxProfilerName},
+		{Name: "appsettings.json", Content: "{}"},
+	}
+
+	var nugetFiles []testArchiveEntry
+	for _, entry := range agentFiles {
+		if entry.Type == 0 {
+			entry.Name = "content/" + entry.Name
+			nugetFiles = append(nugetFiles, entry)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		content []byte
+	}{
+		{"tar.gz", "https://agents.example.com/agent.tar.gz", buildTestTar(t, true, agentFiles)},
+		{"nupkg", "https://agents.example.com/agent.nupkg", buildTestZip(t, nugetFiles)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileSystem := newFakeFileSystem()
+			client := &fakeHTTPClient{content: map[string][]byte{test.url: test.content}}
+
+			installer := NewAgentInstaller(libbuildpack.NewLogger(io.Discard), &SealightsOptions{CustomAgentUrl: test.url})
+			installer.FileSystem = fileSystem
+			installer.HTTPClient = client
+
+			installationPath := "/home/vcap/app/sealights"
+			version, err := installer.InstallAgentToDir(installationPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if version != "1.2.3" {
+				t.Errorf("expected version '1.2.3', got '%s'", version)
+			}
+			if len(client.requests) != 1 {
+				t.Errorf("expected one download, got %v", client.requests)
+			}
+
+			agent, err := fileSystem.Stat(filepath.Join(installationPath, "SL.DotNet"))
+			if err != nil || agent.Mode().Perm() != ExecutableFileMode {
+				t.Errorf("agent is expected to be executable: %v", err)
+			}
+			if _, err = fileSystem.Stat(filepath.Join(installationPath, "content")); !os.IsNotExist(err) {
+				t.Error("content directory of the nuget package is expected to be removed")
+			}
+			for name := range fileSystem.files {
+				if strings.HasPrefix(name, "/tmp/") {
+					t.Errorf("temporary file '%s' is not removed", name)
+				}
+			}
+		})
+	}
+}
+
+func TestReleaseInfoWithFakes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake file system uses slash separated paths")
+	}
+
+	fileSystem := newFakeFileSystem()
+	releaseFile := filepath.Join("/tmp/app", "tmp", ReleaseFileName)
+	fileSystem.WriteFile(releaseFile, []byte("default_process_types:\n  web: cd app && exec ./app\n"), 0644)
+
+	releaseInfo, err := NewReleaseInfo(fileSystem, "/tmp/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = releaseInfo.SetStartCommand("cd app && exec sealights/SL.DotNet && exec ./app"); err != nil {
+		t.Fatal(err)
+	}
+
+	releaseInfo, err = NewReleaseInfo(fileSystem, "/tmp/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if command := releaseInfo.GetStartCommand(); command != "cd app && exec sealights/SL.DotNet && exec ./app" {
+		t.Errorf("unexpected start command '%s'", command)
+	}
+	if len(fileSystem.children(filepath.Dir(releaseFile))) != 1 {
+		t.Error("temporary release file is not removed")
+	}
+}
+
+func TestDetectApplicationWithFakes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake file system uses slash separated paths")
+	}
+
+	fileSystem := newFakeFileSystem()
+	fileSystem.WriteFile("/deps/0/dotnet_publish/app.runtimeconfig.json", []byte(`{"runtimeOptions":{"framework":{"name":"Microsoft.NETCore.App"}}}`), 0644)
+	fileSystem.WriteFile("/deps/0/dotnet_publish/app.deps.json", []byte(`{"runtimeTarget":{"name":".NETCoreApp,Version=v8.0/linux-x64"}}`), 0644)
+	fileSystem.WriteFile("/deps/0/bundle/app", append([]byte("\x7fELF"), bundleSignature...), 0755)
+	fileSystem.WriteFile("/app/Web.config", []byte("<configuration />"), 0644)
+
+	tests := []struct {
+		publishDir string
+		name       string
+		kind       AppKind
+	}{
+		{"/deps/0/dotnet_publish", "", AppKindFrameworkDependent},
+		{"/deps/0/bundle", "app", AppKindSingleFile},
+		{"/app", "", AppKindNetFramework},
+		{"/missing", "", AppKindUnknown},
+	}
+
+	for _, test := range tests {
+		app, err := DetectApplication(fileSystem, test.publishDir, test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if app.Kind != test.kind {
+			t.Errorf("expected %s in '%s', got %s", test.kind, test.publishDir, app.Kind)
+		}
+	}
+}
+
+func TestPlanStartParametersWithFakes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake file system uses slash separated paths")
+	}
+
+	fileSystem := newFakeFileSystem()
+	fileSystem.WriteFile(filepath.Join("/app", "tmp", ReleaseFileName),
+		[]byte("default_process_types:\n  web: cd ${DEPS_DIR}/0/dotnet_publish && exec ./app --server.urls http://0.0.0.0:${PORT}\n"), 0644)
+	fileSystem.WriteFile("/deps/0/dotnet_publish/app.runtimeconfig.json", []byte(`{"runtimeOptions":{"framework":{"name":"Microsoft.NETCore.App"}}}`), 0644)
+
+	log := libbuildpack.NewLogger(io.Discard)
+	stager := libbuildpack.NewStager([]string{"/app", "/cache", "/deps", "0"}, log, nil)
+	options := &SealightsOptions{Verb: "startBackgroundTestListener", SlArguments: map[string]string{"tokenFile": "token.txt"}}
+
+	launcher := NewLauncher(log, options, AgentDir, stager)
+	launcher.FileSystem = fileSystem
+
+	plan, err := launcher.PlanStartParameters(stager)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plan.Application == nil || plan.Application.Kind != AppKindFrameworkDependent {
+		t.Errorf("expected %s application, got %+v", AppKindFrameworkDependent, plan.Application)
+	}
+	if !strings.Contains(plan.StartCommand, "SL.DotNet startBackgroundTestListener --tokenFile token.txt") {
+		t.Errorf("unexpected start command '%s'", plan.StartCommand)
+	}
+}
+
+func TestOsFileSystemWriteFile(t *testing.T) {
+	fileSystem := OsFileSystem{}
+	destFile := filepath.Join(t.TempDir(), "profile.d", "sealights.sh")
+
+	if exists, err := fileExists(fileSystem, destFile); err != nil || exists {
+		t.Fatalf("file is not expected to exist (%v)", err)
+	}
+
+	// parent directories are created by the file system
+	if err := writeFile(fileSystem, strings.NewReader("export A=1\n"), destFile, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fileSystem.AppendFile(destFile, []byte("export B=2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if exists, err := fileExists(fileSystem, destFile); err != nil || !exists {
+		t.Fatalf("file is expected to exist (%v)", err)
+	}
+	data, err := os.ReadFile(destFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "export A=1\nexport B=2\n" {
+		t.Errorf("unexpected content:\n%s", data)
+	}
+}
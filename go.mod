@@ -2,10 +2,12 @@ module github.com/Sealights/libbuildpack-sealights
 
 go 1.18
 
-require github.com/cloudfoundry/libbuildpack v0.0.0-20230331144814-0b11b8e0551a
+require (
+	github.com/cloudfoundry/libbuildpack v0.0.0-20230331144814-0b11b8e0551a
+	gopkg.in/yaml.v2 v2.4.0
+)
 
 require (
 	github.com/Masterminds/semver v1.5.0 // indirect
 	github.com/blang/semver v3.5.1+incompatible // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
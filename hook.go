@@ -16,16 +16,114 @@ type Command interface {
 // SealightsHook implements libbuildpack.Hook. It downloads and install the Sealights OneAgent.
 type SealightsHook struct {
 	libbuildpack.DefaultHook
-	Log     *libbuildpack.Logger
-	Command Command
+	Log        *libbuildpack.Logger
+	Command    Command
+	FileSystem FileSystem
+
+	// HTTPClient replaces the client created from the proxy options if provided
+	HTTPClient HTTPClient
 }
 
 // NewHook returns a libbuildpack.Hook instance for integrating with Sealights
 func NewHook() libbuildpack.Hook {
 	return &SealightsHook{
-		Log:     libbuildpack.NewLogger(os.Stdout),
-		Command: &libbuildpack.Command{},
+		Log:        libbuildpack.NewLogger(os.Stdout),
+		Command:    &libbuildpack.Command{},
+		FileSystem: OsFileSystem{},
+	}
+}
+
+// BeforeCompile installs the Sealights agent into the dependency directory when the
+// supply phase is selected. It runs in the supply context, so it works also when the
+// buildpack is not the final one
+func (h *SealightsHook) BeforeCompile(stager *libbuildpack.Stager) error {
+	conf := h.newConfiguration(stager, "")
+	if !conf.UseSealights() || conf.Value.StagingPhase != StagingPhaseSupply {
+		return nil
 	}
+
+	h.Log.Info("Sealights. Service is enabled (supply phase)")
+
+	if isDryRun() {
+		return h.printSupplyPlan(conf, stager)
+	}
+
+	return h.Supply(conf, stager)
+}
+
+// Supply downloads and installs the Sealights agent into the dependency directory
+// and configures the application via the profile.d script
+func (h *SealightsHook) Supply(conf *Configuration, stager *libbuildpack.Stager) error {
+	steps := NewStagingSteps(h.Log, conf.Value.FailurePolicy)
+	defer steps.PrintSummary()
+
+	_, err := steps.Run(StepExpandTemplates, func() error {
+		return ExpandOptionTemplates(conf.Value)
+	})
+	if err != nil {
+		return err
+	}
+
+	agentInstaller := h.newAgentInstaller(conf.Value)
+	supplier := NewSupplier(h.Log, conf.Value, stager)
+	supplier.FileSystem = h.FileSystem
+
+	var agentVersion string
+	installed, err := steps.Run(StepInstallAgent, func() (err error) {
+		agentVersion, err = agentInstaller.InstallAgentToDir(supplier.AgentDirAbsolute)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !installed {
+		steps.Skip(StepPlanSupply, "agent is not installed")
+		return nil
+	}
+	h.Log.Info("Sealights. Agent is installed (version: %s)", agentVersion)
+
+	if conf.Value.BuildSessionVerb != "" {
+		_, err = steps.Run(StepCreateBuildSession, func() error {
+			buildSession := NewBuildSession(h.Log, conf.Value, h.Command)
+			buildSession.FileSystem = h.FileSystem
+			return buildSession.Create(supplier.AgentDirAbsolute, supplier.AgentDirForRuntime)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var plan *SupplyPlan
+	planned, err := steps.Run(StepPlanSupply, func() (err error) {
+		plan, err = supplier.PlanSupply()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if planned {
+		err = supplier.ApplyPlan(plan, steps)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = steps.Run(StepWriteStagingReport, func() error {
+		report := NewStagingReport(conf, agentInstaller, agentVersion, nil, steps)
+		return report.Write(h.FileSystem, supplier.AgentDirAbsolute)
+	})
+	if err != nil {
+		return err
+	}
+
+	if steps.IsDegraded() {
+		h.Log.Warning("Sealights. Service is supplied partially")
+	} else {
+		h.Log.Info("Sealights. Service is supplied")
+	}
+
+	return nil
 }
 
 // AfterCompile downloads and installs the Sealights agent, and modify application start command
@@ -33,7 +131,7 @@ func (h *SealightsHook) AfterCompile(stager *libbuildpack.Stager) error {
 
 	h.Log.Debug("Sealights. Check service status...")
 
-	conf := NewConfiguration(h.Log, stager)
+	conf := h.newConfiguration(stager, "")
 	if !conf.UseSealights() {
 		h.Log.Debug("Sealights service isn't configured")
 		return nil
@@ -41,18 +139,99 @@ func (h *SealightsHook) AfterCompile(stager *libbuildpack.Stager) error {
 
 	h.Log.Info("Sealights. Service is enabled")
 
-	agentInstaller := NewAgentInstaller(h.Log, conf.Value)
+	if conf.Value.StagingPhase == StagingPhaseSupply {
+		h.Log.Info("Sealights. Agent is supplied to the dependency directory, start command will not be modified")
+		return nil
+	}
+
+	if isDryRun() {
+		return h.printPlan(conf, stager)
+	}
+
+	steps := NewStagingSteps(h.Log, conf.Value.FailurePolicy)
+	defer steps.PrintSummary()
 
-	agentDir, agentVersion, err := agentInstaller.InstallAgent(stager)
+	_, err := steps.Run(StepExpandTemplates, func() error {
+		return ExpandOptionTemplates(conf.Value)
+	})
 	if err != nil {
 		return err
 	}
+
+	agentInstaller := h.newAgentInstaller(conf.Value)
+
+	var agentDir, agentVersion string
+	installed, err := steps.Run(StepInstallAgent, func() (err error) {
+		agentDir, agentVersion, err = agentInstaller.InstallAgent(stager)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !installed {
+		steps.Skip(StepModifyStartParameters, "agent is not installed")
+		return nil
+	}
 	h.Log.Info("Sealights. Agent is installed (version: %s)", agentVersion)
 
-	launcher := NewLauncher(h.Log, conf.Value, agentDir, stager)
-	launcher.ModifyStartParameters(stager)
+	launcher := h.newLauncher(conf.Value, agentDir, stager)
 
-	h.Log.Info("Sealights. Service is set up")
+	if conf.Value.BuildSessionVerb != "" {
+		_, err = steps.Run(StepCreateBuildSession, func() error {
+			buildSession := NewBuildSession(h.Log, conf.Value, h.Command)
+			buildSession.FileSystem = h.FileSystem
+			return buildSession.Create(launcher.AgentDirAbsolute, launcher.AgentDirForRuntime)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	plan, err := launcher.ModifyStartParameters(stager, steps)
+	if err != nil {
+		return err
+	}
+
+	_, err = steps.Run(StepWriteStagingReport, func() error {
+		report := NewStagingReport(conf, agentInstaller, agentVersion, plan, steps)
+		return report.Write(h.FileSystem, launcher.AgentDirAbsolute)
+	})
+	if err != nil {
+		return err
+	}
+
+	if steps.IsDegraded() {
+		h.Log.Warning("Sealights. Service is set up partially")
+	} else {
+		h.Log.Info("Sealights. Service is set up")
+	}
 
 	return nil
 }
+
+// Staging phase of the options is replaced by the provided one if it isn't empty
+func (h *SealightsHook) newConfiguration(stager *libbuildpack.Stager, stagingPhase string) *Configuration {
+	conf := &Configuration{Log: h.Log, Stager: stager, ToolName: DefaultToolName, StagingPhase: stagingPhase, FileSystem: h.FileSystem}
+	conf.Load()
+
+	return conf
+}
+
+// Installer and launcher use the hook executors, so the fakes provided to the hook are used by all the steps
+func (h *SealightsHook) newAgentInstaller(options *SealightsOptions) *AgentInstaller {
+	agentInstaller := NewAgentInstaller(h.Log, options)
+	agentInstaller.Command = h.Command
+	agentInstaller.FileSystem = h.FileSystem
+	if h.HTTPClient != nil {
+		agentInstaller.HTTPClient = h.HTTPClient
+	}
+
+	return agentInstaller
+}
+
+func (h *SealightsHook) newLauncher(options *SealightsOptions, agentInstallationDir string, stager *libbuildpack.Stager) *Launcher {
+	launcher := NewLauncher(h.Log, options, agentInstallationDir, stager)
+	launcher.FileSystem = h.FileSystem
+
+	return launcher
+}
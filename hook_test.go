@@ -0,0 +1,282 @@
+package sealights_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	sealights "github.com/Sealights/libbuildpack-sealights"
+	"github.com/Sealights/libbuildpack-sealights/sealightstest"
+)
+
+func skipOnWindows(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("staging is tested with the linux start command")
+	}
+}
+
+func TestAfterCompileInstallsAgent(t *testing.T) {
+	skipOnWindows(t)
+
+	server := sealightstest.NewAgentServer(t)
+	tests := []struct {
+		name        string
+		credentials sealightstest.Credentials
+		request     string
+	}{
+		{"tar.gz", sealightstest.Credentials{"customAgentUrl": server.PackageUrl(sealightstest.PackageTarGz)}, "/agent/agent.tar.gz"},
+		{"zip", sealightstest.Credentials{"customAgentUrl": server.PackageUrl(sealightstest.PackageZip)}, "/agent/agent.zip"},
+		{"nuget feed", sealightstest.Credentials{
+			"nugetFeed":      server.NugetFeedUrl(),
+			"nugetPackageId": server.NugetPackageId,
+			"version":        sealightstest.DefaultAgentVersion,
+		}, ".nupkg"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := sealightstest.NewStagingEnvironment(t)
+			test.credentials["verb"] = "startBackgroundTestListener"
+			test.credentials["tokenFile"] = "token.txt"
+			sealightstest.SetVcapServices(t, test.credentials)
+
+			if err := env.Hook().AfterCompile(env.Stager()); err != nil {
+				t.Fatal(err)
+			}
+
+			requests := server.Requests()
+			if len(requests) == 0 || !strings.HasSuffix(requests[len(requests)-1], test.request) {
+				t.Errorf("expected package request '%s', got %v", test.request, requests)
+			}
+
+			env.AssertFileExists(filepath.Join(env.AgentDir(), sealights.LinuxAgentName))
+			env.AssertFileExists(filepath.Join(env.AgentDir(), sealights.StagingReportFileName))
+			env.AssertStartCommandContains("SL.DotNet startBackgroundTestListener")
+			env.AssertStartCommandContains("--tokenFile token.txt")
+			env.AssertStartCommandContains("exec ./app")
+			env.AssertVariable(env.AgentEnv(), "CORECLR_ENABLE_PROFILING", "1")
+			env.AssertLogContains("Agent is installed (version: " + sealightstest.DefaultAgentVersion + ")")
+		})
+	}
+}
+
+func TestAfterCompileWithoutService(t *testing.T) {
+	env := sealightstest.NewStagingEnvironment(t)
+	sealightstest.UnbindServices(t)
+
+	if err := env.Hook().AfterCompile(env.Stager()); err != nil {
+		t.Fatal(err)
+	}
+
+	env.AssertStartCommandUnchanged()
+	if _, err := os.Stat(env.AgentDir()); !os.IsNotExist(err) {
+		t.Error("agent must not be installed without the service")
+	}
+}
+
+func TestAfterCompileWithPic(t *testing.T) {
+	skipOnWindows(t)
+
+	server := sealightstest.NewAgentServer(t)
+	env := sealightstest.NewStagingEnvironment(t)
+	sealightstest.SetVcapServices(t, sealightstest.Credentials{
+		"customAgentUrl": server.PackageUrl(sealightstest.PackageTarGz),
+		"usePic":         true,
+		"tokenFile":      "token.txt",
+	})
+
+	if err := env.Hook().AfterCompile(env.Stager()); err != nil {
+		t.Fatal(err)
+	}
+
+	// profiler initializes the collector itself, so the listener isn't started
+	env.AssertStartCommandUnchanged()
+	profileD := env.ProfileD()
+	env.AssertVariable(profileD, "CORECLR_ENABLE_PROFILING", "1")
+	env.AssertVariable(profileD, "SL_PROFILER_INITIALIZECOLLECTOR", "1")
+	env.AssertVariable(profileD, "SL_PROFILER_BLOCKING_CONNECTION_STARTUP", "ASYNC")
+}
+
+func TestAfterCompileWithEnv(t *testing.T) {
+	skipOnWindows(t)
+
+	server := sealightstest.NewAgentServer(t)
+	env := sealightstest.NewStagingEnvironment(t)
+	sealightstest.SetVcapServices(t, sealightstest.Credentials{
+		"customAgentUrl": server.PackageUrl(sealightstest.PackageTarGz),
+		"verb":           "startBackgroundTestListener",
+		"labId":          "ignored-lab",
+		"cli":            map[string]interface{}{"tokenFile": "token.txt"},
+		"env":            map[string]interface{}{"SL_LOG_LEVEL": "6", "CORECLR_ENABLE_PROFILING": "0"},
+	})
+
+	if err := env.Hook().AfterCompile(env.Stager()); err != nil {
+		t.Fatal(err)
+	}
+
+	// with 'env' only the 'cli' options are passed to the agent
+	env.AssertStartCommandContains("--tokenFile token.txt")
+	if strings.Contains(env.StartCommand(), "ignored-lab") {
+		t.Errorf("option outside of 'cli' is passed to the agent: %s", env.StartCommand())
+	}
+
+	agentEnv := env.AgentEnv()
+	env.AssertVariable(agentEnv, "SL_LOG_LEVEL", "6")
+	env.AssertVariable(agentEnv, "CORECLR_ENABLE_PROFILING", "0")
+}
+
+func TestAfterCompileWithCliPort(t *testing.T) {
+	skipOnWindows(t)
+
+	server := sealightstest.NewAgentServer(t)
+	tests := []struct {
+		port     string
+		expected string
+	}{
+		{"32000", "--port 32000"},
+		// 'auto' requires the supervisor, the agent listens on the default port
+		{"auto", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.port, func(t *testing.T) {
+			env := sealightstest.NewStagingEnvironment(t)
+			sealightstest.SetVcapServices(t, sealightstest.Credentials{
+				"customAgentUrl": server.PackageUrl(sealightstest.PackageTarGz),
+				"verb":           "startBackgroundTestListener",
+				"cli":            map[string]interface{}{"tokenFile": "token.txt", "port": test.port},
+			})
+
+			if err := env.Hook().AfterCompile(env.Stager()); err != nil {
+				t.Fatal(err)
+			}
+
+			startCommand := env.StartCommand()
+			if count := strings.Count(startCommand, "--port"); test.expected == "" && count != 0 || test.expected != "" && count != 1 {
+				t.Errorf("unexpected port arguments in the start command: %s", startCommand)
+			}
+			if test.expected != "" {
+				env.AssertStartCommandContains(test.expected)
+			}
+		})
+	}
+}
+
+func TestAfterCompileDryRun(t *testing.T) {
+	server := sealightstest.NewAgentServer(t)
+	env := sealightstest.NewStagingEnvironment(t)
+	sealightstest.SetVcapServices(t, sealightstest.Credentials{
+		"customAgentUrl": server.PackageUrl(sealightstest.PackageTarGz),
+		"verb":           "startBackgroundTestListener",
+		"token":          "secret-token",
+	})
+	t.Setenv(sealights.DryRunEnvVariable, "true")
+
+	releaseFile := filepath.Join(env.BuildDir, "tmp", sealights.ReleaseFileName)
+	original := env.ReadFile(releaseFile)
+
+	if err := env.Hook().AfterCompile(env.Stager()); err != nil {
+		t.Fatal(err)
+	}
+
+	if content := env.ReadFile(releaseFile); content != original {
+		t.Errorf("release file is modified in the dry run:\n%s", content)
+	}
+	if requests := server.Requests(); len(requests) != 0 {
+		t.Errorf("nothing is expected to be downloaded, got %v", requests)
+	}
+	if _, err := os.Stat(env.AgentDir()); !os.IsNotExist(err) {
+		t.Error("agent must not be installed in the dry run")
+	}
+	if strings.Contains(env.Output.String(), "secret-token") {
+		t.Error("token is printed to the staging log")
+	}
+	env.AssertLogContains("Dry run mode is enabled")
+}
+
+func TestBeforeCompileSupplyPhase(t *testing.T) {
+	skipOnWindows(t)
+
+	server := sealightstest.NewAgentServer(t)
+	env := sealightstest.NewStagingEnvironment(t)
+	sealightstest.SetVcapServices(t, sealightstest.Credentials{
+		"customAgentUrl": server.PackageUrl(sealightstest.PackageTarGz),
+		"verb":           "startBackgroundTestListener",
+		"stagingPhase":   "supply",
+		"tokenFile":      "token.txt",
+	})
+
+	hook := env.Hook()
+	stager := env.Stager()
+	if err := hook.BeforeCompile(stager); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.AfterCompile(stager); err != nil {
+		t.Fatal(err)
+	}
+
+	agentDir := filepath.Join(env.DepDir(), sealights.AgentDir)
+	env.AssertFileExists(filepath.Join(agentDir, sealights.LinuxAgentName))
+	env.AssertFileExists(filepath.Join(env.DepDir(), "bin", sealights.LinuxAgentName))
+	if _, err := os.Stat(env.AgentDir()); !os.IsNotExist(err) {
+		t.Error("agent must be installed into the dependency directory only")
+	}
+
+	profileDScript := filepath.Join(env.DepDir(), "profile.d", "sealights.sh")
+	if script := env.ReadFile(profileDScript); !strings.Contains(script, "SL.DotNet startBackgroundTestListener") {
+		t.Errorf("listener is not started by the profile.d script:\n%s", script)
+	}
+	env.AssertVariable(env.ReadEnvFile(profileDScript), "CORECLR_ENABLE_PROFILING", "1")
+	env.AssertStartCommandUnchanged()
+}
+
+func TestAfterCompileFailurePolicy(t *testing.T) {
+	skipOnWindows(t)
+
+	server := sealightstest.NewAgentServer(t)
+	tests := []struct {
+		policy        string
+		expectError   bool
+		reportWritten bool
+	}{
+		{"fail", true, false},
+		{"warn", false, true},
+		{"skip", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			env := sealightstest.NewStagingEnvironment(t)
+			// the start command can't be modified without 'cd ... &&' part
+			env.WriteReleaseFile("./app --server.urls http://0.0.0.0:${PORT}")
+			sealightstest.SetVcapServices(t, sealightstest.Credentials{
+				"customAgentUrl": server.PackageUrl(sealightstest.PackageTarGz),
+				"verb":           "startBackgroundTestListener",
+				"tokenFile":      "token.txt",
+				"failurePolicy":  test.policy,
+			})
+
+			err := env.Hook().AfterCompile(env.Stager())
+
+			var stepError *sealights.StepError
+			if test.expectError != errors.As(err, &stepError) {
+				t.Fatalf("unexpected staging result: %v", err)
+			}
+			if !test.expectError {
+				env.AssertLogContains("Integration is degraded (failure policy: " + test.policy + ")")
+			}
+
+			if command := env.StartCommand(); command != "./app --server.urls http://0.0.0.0:${PORT}" {
+				t.Errorf("start command is modified: %s", command)
+			}
+
+			_, err = os.Stat(filepath.Join(env.AgentDir(), sealights.StagingReportFileName))
+			if reportWritten := err == nil; reportWritten != test.reportWritten {
+				t.Errorf("expected staging report written %v, got %v", test.reportWritten, reportWritten)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package sealights
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+const InstanceIndexEnvVariable = "CF_INSTANCE_INDEX"
+const InstanceGuidEnvVariable = "CF_INSTANCE_GUID"
+
+var tagUnsafeCharacters = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// InstanceInfo identifies the application instance for the agent. Application fields
+// are known at staging, instance fields are references to the env variables expanded
+// when the instance starts
+type InstanceInfo struct {
+	AppName       string
+	AppId         string
+	SpaceName     string
+	OrgName       string
+	InstanceIndex string
+	InstanceGuid  string
+}
+
+type vcapApplicationModel struct {
+	ApplicationName  string `json:"application_name"`
+	ApplicationId    string `json:"application_id"`
+	SpaceName        string `json:"space_name"`
+	OrganizationName string `json:"organization_name"`
+}
+
+// NewInstanceInfo reads application fields from VCAP_APPLICATION available at staging
+func NewInstanceInfo() (*InstanceInfo, error) {
+	var application vcapApplicationModel
+	if vcapApplication := os.Getenv("VCAP_APPLICATION"); vcapApplication != "" {
+		if err := json.Unmarshal([]byte(vcapApplication), &application); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal VCAP_APPLICATION: %w", err)
+		}
+	}
+
+	return &InstanceInfo{
+		AppName:       sanitizeTag(application.ApplicationName),
+		AppId:         sanitizeTag(application.ApplicationId),
+		SpaceName:     sanitizeTag(application.SpaceName),
+		OrgName:       sanitizeTag(application.OrganizationName),
+		InstanceIndex: runtimeVariableReference(InstanceIndexEnvVariable),
+		InstanceGuid:  runtimeVariableReference(InstanceGuidEnvVariable),
+	}, nil
+}
+
+// RenderInstanceTag executes the template, e.g. '{{.AppName}}-{{.InstanceIndex}}'
+func (info *InstanceInfo) RenderInstanceTag(tagTemplate string) (string, error) {
+	data := &TemplateData{InstanceInfo: *info}
+	return data.expand("instanceTag", tagTemplate)
+}
+
+// Reference is expanded by the shell of the start command, the supervisor
+// and exec.d expand it with expandInstanceVariables
+func runtimeVariableReference(name string) string {
+	if runtime.GOOS == "windows" {
+		return "%" + name + "%"
+	}
+
+	return "${" + name + "}"
+}
+
+// Expand only the instance variables and the home directory of the runtime paths,
+// the rest of the arguments is kept as is
+func expandInstanceVariables(arguments []string) []string {
+	homeDir, _ := os.Getwd()
+	if runtime.GOOS != "windows" {
+		homeDir = os.Getenv("HOME")
+	}
+
+	replacer := strings.NewReplacer(
+		runtimeVariableReference(InstanceIndexEnvVariable), os.Getenv(InstanceIndexEnvVariable),
+		runtimeVariableReference(InstanceGuidEnvVariable), os.Getenv(InstanceGuidEnvVariable),
+		runtimeHomeDir(), homeDir,
+	)
+
+	expanded := make([]string, len(arguments))
+	for i, argument := range arguments {
+		expanded[i] = replacer.Replace(argument)
+	}
+
+	return expanded
+}
+
+// Values are the part of the command line, so only the safe characters are kept
+func sanitizeTag(value string) string {
+	return tagUnsafeCharacters.ReplaceAllString(value, "-")
+}
@@ -0,0 +1,52 @@
+package sealights
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestNewInstanceInfo(t *testing.T) {
+	t.Setenv("VCAP_APPLICATION", `{"application_name":"orders api/v2","application_id":"1f2e","space_name":"dev","organization_name":"acme"}`)
+
+	info, err := NewInstanceInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.AppName != "orders-api-v2" || info.AppId != "1f2e" || info.SpaceName != "dev" || info.OrgName != "acme" {
+		t.Errorf("unexpected application fields: %+v", info)
+	}
+
+	tag, err := info.RenderInstanceTag("{{.OrgName}}-{{.AppName}}-{{.InstanceIndex}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "acme-orders-api-v2-" + runtimeVariableReference(InstanceIndexEnvVariable); tag != expected {
+		t.Errorf("expected tag '%s', got '%s'", expected, tag)
+	}
+
+	if _, err = info.RenderInstanceTag("{{.Unknown}}"); err == nil {
+		t.Error("unknown field is expected to fail the template")
+	}
+}
+
+func TestExpandInstanceVariables(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("runtime paths are checked with the linux home directory")
+	}
+
+	t.Setenv("HOME", "/home/vcap/app")
+	t.Setenv(InstanceIndexEnvVariable, "2")
+	t.Setenv(InstanceGuidEnvVariable, "abc")
+
+	expanded := expandInstanceVariables([]string{
+		"--tagName", "orders-${CF_INSTANCE_INDEX}-${CF_INSTANCE_GUID}",
+		"--tokenFile", runtimeHomeDir() + "/token.txt",
+		"${OTHER}",
+	})
+	expected := []string{"--tagName", "orders-2-abc", "--tokenFile", "/home/vcap/app/token.txt", "${OTHER}"}
+	for i := range expected {
+		if expanded[i] != expected[i] {
+			t.Errorf("expected '%s', got '%s'", expected[i], expanded[i])
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package sealights
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+type InstrumentationMode string
+
+const (
+	// InstrumentationModeProfiler attaches the native CLR profiler
+	InstrumentationModeProfiler InstrumentationMode = "profiler"
+	// InstrumentationModeStartupHook loads the managed Sealights assembly with DOTNET_STARTUP_HOOKS
+	InstrumentationModeStartupHook InstrumentationMode = "startupHook"
+	// InstrumentationModeNone doesn't instrument the application, only the agent is started
+	InstrumentationModeNone InstrumentationMode = "none"
+)
+
+const DefaultInstrumentationMode = InstrumentationModeProfiler
+const StartupHookAssemblyName = "SL.DotNet.StartupHook.dll"
+const StartupHooksEnvVariable = "DOTNET_STARTUP_HOOKS"
+
+var ErrStartupHookNotFound = errors.New("startup hook assembly is not found in the agent package")
+
+func ParseInstrumentationMode(value string) (InstrumentationMode, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return DefaultInstrumentationMode, nil
+	}
+
+	for _, mode := range []InstrumentationMode{InstrumentationModeProfiler, InstrumentationModeStartupHook, InstrumentationModeNone} {
+		if strings.EqualFold(value, string(mode)) {
+			return mode, nil
+		}
+	}
+
+	return DefaultInstrumentationMode, fmt.Errorf("unknown instrumentation mode '%s'", value)
+}
+
+// Check the installed agent provides everything required by the instrumentation mode
+func validateInstrumentationMode(fileSystem FileSystem, mode InstrumentationMode, agentDir string) error {
+	if mode != InstrumentationModeStartupHook {
+		return nil
+	}
+
+	exists, err := fileExists(fileSystem, filepath.Join(agentDir, StartupHookAssemblyName))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: '%s'", ErrStartupHookNotFound, StartupHookAssemblyName)
+	}
+
+	return nil
+}
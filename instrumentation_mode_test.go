@@ -0,0 +1,45 @@
+package sealights
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseInstrumentationMode(t *testing.T) {
+	tests := map[string]InstrumentationMode{
+		"":              InstrumentationModeProfiler,
+		"profiler":      InstrumentationModeProfiler,
+		" startuphook ": InstrumentationModeStartupHook,
+		"None":          InstrumentationModeNone,
+	}
+	for value, expected := range tests {
+		mode, err := ParseInstrumentationMode(value)
+		if err != nil || mode != expected {
+			t.Errorf("'%s': expected '%s', got '%s' (%v)", value, expected, mode, err)
+		}
+	}
+
+	if mode, err := ParseInstrumentationMode("hook"); err == nil || mode != DefaultInstrumentationMode {
+		t.Errorf("unknown mode is expected to fall back to the default with an error, got '%s' (%v)", mode, err)
+	}
+}
+
+func TestValidateInstrumentationMode(t *testing.T) {
+	agentDir := t.TempDir()
+
+	if err := validateInstrumentationMode(OsFileSystem{}, InstrumentationModeProfiler, agentDir); err != nil {
+		t.Errorf("profiler mode doesn't require the startup hook: %v", err)
+	}
+	if err := validateInstrumentationMode(OsFileSystem{}, InstrumentationModeStartupHook, agentDir); !errors.Is(err, ErrStartupHookNotFound) {
+		t.Errorf("expected missing startup hook error, got %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(agentDir, StartupHookAssemblyName), []byte("MZ"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateInstrumentationMode(OsFileSystem{}, InstrumentationModeStartupHook, agentDir); err != nil {
+		t.Errorf("startup hook is installed: %v", err)
+	}
+}
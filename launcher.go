@@ -1,7 +1,9 @@
 package sealights
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -14,6 +16,9 @@ import (
 const WindowsAgentName = "SL.DotNet.exe"
 const LinuxAgentName = "SL.DotNet"
 const GlobalVariablesFile = "sealights-env.sh"
+const WindowsGlobalVariablesFile = "sealights-env.bat"
+
+var ErrUnsupportedStartCommand = errors.New("unsupported start command format")
 
 type Launcher struct {
 	Log                *libbuildpack.Logger
@@ -21,37 +26,236 @@ type Launcher struct {
 	AgentDirAbsolute   string
 	AgentDirForRuntime string
 	Stager             *libbuildpack.Stager
+	App                *AppInfo
+	FileSystem         FileSystem
 }
 
 func NewLauncher(log *libbuildpack.Logger, options *SealightsOptions, agentInstallationDir string, stager *libbuildpack.Stager) *Launcher {
-	agentDirForRuntime := filepath.Join("${HOME}", agentInstallationDir)
+	agentDirForRuntime := filepath.Join(runtimeHomeDir(), agentInstallationDir)
 	agentDirAbsolute := filepath.Join(stager.BuildDir(), agentInstallationDir)
-	return &Launcher{Log: log, Options: options, AgentDirForRuntime: agentDirForRuntime, AgentDirAbsolute: agentDirAbsolute, Stager: stager}
+	return &Launcher{Log: log, Options: options, AgentDirForRuntime: agentDirForRuntime, AgentDirAbsolute: agentDirAbsolute, Stager: stager, FileSystem: OsFileSystem{}}
+}
+
+// LaunchPlan describes changes of the application start parameters
+// without applying them
+type LaunchPlan struct {
+	ReleaseInfo        *ReleaseInfo
+	OriginalCommand    string
+	StartCommand       string
+	ShouldApply        bool
+	AgentEnvFile       string
+	AgentEnvVariables  map[string]string
+	GlobalEnvFile      string
+	GlobalEnvVariables map[string]string
+	ProfileDFile       string
+	Application        *AppInfo
+	SidecarCommand     string
+	Supervisor         *SupervisorConfig
+
+	// ListenerCommand is started by the profile.d script when the start command can't be modified
+	ListenerCommand string
+}
+
+// ModifyStartParameters plans and applies changes of the start parameters.
+// Returns the plan, or nil if the planning step failed
+func (la *Launcher) ModifyStartParameters(stager *libbuildpack.Stager, steps *StagingSteps) (*LaunchPlan, error) {
+	var plan *LaunchPlan
+	planned, err := steps.Run(StepPlanStartParameters, func() (err error) {
+		plan, err = la.PlanStartParameters(stager)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !planned {
+		steps.Skip(StepUpdateStartCommand, "start parameters are not planned")
+		return nil, nil
+	}
+
+	return plan, la.ApplyPlan(plan, steps)
 }
 
-func (la *Launcher) ModifyStartParameters(stager *libbuildpack.Stager) error {
+// PlanStartParameters computes new start command and env files content
+func (la *Launcher) PlanStartParameters(stager *libbuildpack.Stager) (*LaunchPlan, error) {
 	la.updateAgentPath(stager)
 
-	releaseInfo := NewReleaseInfo(stager.BuildDir())
+	plan := &LaunchPlan{
+		ShouldApply: la.Options.Verb != "" || la.Options.CustomCommand != "",
+	}
+
+	if plan.ShouldApply {
+		isHwc, err := isHwcRelease(la.FileSystem, stager.BuildDir())
+		if err != nil {
+			return nil, err
+		}
+		if isHwc {
+			return la.planHwcStartParameters(stager, plan)
+		}
+	}
+
+	if plan.ShouldApply {
+		releaseInfo, err := NewReleaseInfo(la.FileSystem, stager.BuildDir())
+		if err != nil {
+			return nil, err
+		}
+
+		plan.ReleaseInfo = releaseInfo
+		plan.OriginalCommand = releaseInfo.GetStartCommand()
+		plan.Application = la.detectApplication(stager, plan.OriginalCommand)
+		plan.StartCommand, err = la.updateStartCommand(plan.OriginalCommand, plan)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		plan.Application = la.detectApplication(stager, "")
+	}
+
+	la.planGlobalEnvVariables(plan)
 
-	startCommand := releaseInfo.GetStartCommand()
-	newStartCommand := la.updateStartCommand(startCommand)
+	return plan, nil
+}
 
-	la.setEnvVariablesGlobally()
+// The hwc buildpack prints its static start command itself, so the 'web' process is wrapped
+// by the profile.d script instead: the script starts the background test listener and sets
+// the profiler variables before hwc.exe is started by the platform
+func (la *Launcher) planHwcStartParameters(stager *libbuildpack.Stager, plan *LaunchPlan) (*LaunchPlan, error) {
+	if la.Options.CustomCommand != "" || la.Options.Verb != "startBackgroundTestListener" {
+		return nil, fmt.Errorf("%w: '%s' (only the background test listener is supported for the HWC application)", ErrUnsupportedStartCommand, HwcStartCommand)
+	}
 
-	shouldApply := la.Options.Verb != "" || la.Options.CustomCommand != ""
-	if shouldApply {
-		err := releaseInfo.SetStartCommand(newStartCommand)
+	plan.ShouldApply = false
+	plan.OriginalCommand = HwcStartCommand
+	plan.ListenerCommand = la.agentCommandLine()
+	plan.Application = la.detectApplication(stager, "")
+
+	la.planGlobalEnvVariables(plan)
+	la.Log.Info("Sealights. Start command of the HWC application is static - background test listener is started by the profile.d script")
+
+	return plan, nil
+}
+
+// ApplyPlan writes env files and updates start command in the release info.
+// Every change is a separate step, so the failure policy is applied to each of them.
+// The start command isn't changed if the env file it relies on is not created
+func (la *Launcher) ApplyPlan(plan *LaunchPlan, steps *StagingSteps) error {
+	agentEnvReady := true
+	if plan.AgentEnvVariables != nil {
+		var err error
+		agentEnvReady, err = steps.Run(StepWriteAgentEnvFile, func() error {
+			return la.writeAgentEnvFile(plan)
+		})
 		if err != nil {
 			return err
 		}
+	}
 
-		logMessage := fmt.Sprintf("Sealights: Start command updated. From '%s' to '%s'", startCommand, newStartCommand)
-		la.Log.Info(maskSensitiveData(logMessage))
-	} else {
+	_, err := steps.Run(StepSetEnvVariables, func() error {
+		return la.setEnvVariablesGlobally(plan)
+	})
+	if err != nil {
+		return err
+	}
+
+	if !plan.ShouldApply {
 		la.Log.Debug("Sealights. Start command will not be modified")
+		return nil
+	}
+
+	if !agentEnvReady {
+		steps.Skip(StepUpdateStartCommand, "agent env file is not created")
+		return nil
+	}
+
+	if plan.Supervisor != nil {
+		supervisorReady, err := steps.Run(StepInstallSupervisor, func() error {
+			return installSupervisor(la.FileSystem, la.AgentDirAbsolute, *plan.Supervisor)
+		})
+		if err != nil {
+			return err
+		}
+		if !supervisorReady {
+			steps.Skip(StepUpdateStartCommand, "supervisor is not installed")
+			return nil
+		}
+	}
+
+	if plan.SidecarCommand != "" {
+		_, err = steps.Run(StepRegisterSidecar, func() error {
+			return writeSidecar(la.FileSystem, la.Stager.DepDir(), plan.SidecarCommand)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = steps.Run(StepUpdateStartCommand, func() error {
+		err := plan.ReleaseInfo.SetStartCommand(plan.StartCommand)
+		if err != nil {
+			return err
+		}
+
+		logMessage := fmt.Sprintf("Sealights: Start command updated. From '%s' to '%s'", plan.OriginalCommand, plan.StartCommand)
+		la.Log.Info(maskSensitiveData(logMessage))
+		return nil
+	})
+
+	return err
+}
+
+// Classify the application published into the directory used by the start command.
+// The launch is not blocked if the application can't be recognized
+func (la *Launcher) detectApplication(stager *libbuildpack.Stager, startCommand string) *AppInfo {
+	publishDir := publishDirFromCommand(startCommand, stager.BuildDir(), stager.DepsDir())
+	startTarget := startCommand
+	if parts := strings.SplitAfterN(startCommand, "&& ", 2); len(parts) == 2 {
+		startTarget = parts[1]
+	}
+
+	app, err := DetectApplication(la.FileSystem, publishDir, applicationNameFromCommand(startTarget))
+	if err != nil {
+		la.Log.Warning("Sealights. Failed to detect application type: %s", err)
+		return nil
+	}
+
+	la.Log.Debug("Sealights. Application '%s' is %s (runtime: '%s')", app.Name, app.Kind, app.RuntimeIdentifier)
+
+	switch app.Kind {
+	case AppKindNativeAot:
+		la.Log.Warning("Sealights. Native AOT application '%s' can't be instrumented - profiler will not be configured", app.Name)
+	case AppKindSingleFile:
+		la.Log.Info("Sealights. Single-file application detected - profiler is attached to the bundled runtime")
+	case AppKindUnknown:
+		la.Log.Debug("Sealights. Application type isn't recognized in '%s'", publishDir)
+	}
+
+	if !app.IsSupportedRuntime() {
+		la.Log.Warning("Sealights. Runtime '%s' isn't supported by the profiler", app.RuntimeIdentifier)
 	}
 
+	if la.Options.InstrumentationMode == InstrumentationModeStartupHook && !app.StartupHooksSupported && app.IsInstrumentable() {
+		la.Log.Warning("Sealights. Startup hooks aren't supported by the application '%s' - profiler is configured instead", app.Name)
+	}
+
+	la.App = app
+	return app
+}
+
+func (la *Launcher) newEnvManager() *EnvManager {
+	envManager := NewEnvManager(la.Log, la.Options)
+	envManager.App = la.App
+	envManager.FileSystem = la.FileSystem
+
+	return envManager
+}
+
+func (la *Launcher) writeAgentEnvFile(plan *LaunchPlan) error {
+	envManager := la.newEnvManager()
+	err := envManager.WriteIntoFile(plan.AgentEnvFile, plan.AgentEnvVariables)
+	if err != nil {
+		return fmt.Errorf("failed to create agent env file: %w", err)
+	}
+
+	la.Log.Debug(fmt.Sprintf("Create file %s", filepath.Base(plan.AgentEnvFile)))
 	return nil
 }
 
@@ -62,16 +266,19 @@ func (la *Launcher) updateAgentPath(stager *libbuildpack.Stager) {
 	}
 }
 
-func (la *Launcher) updateStartCommand(originalCommand string) string {
+func (la *Launcher) updateStartCommand(originalCommand string, plan *LaunchPlan) (string, error) {
 	// expected command format:
 	// cd ${DEPS_DIR}/0/dotnet_publish && exec ./app --server.urls http://0.0.0.0:${PORT}
 	// cd ${DEPS_DIR}/0/dotnet_publish && exec dotnet ./app.dll --server.urls http://0.0.0.0:${PORT}
 
 	parts := strings.SplitAfterN(originalCommand, "&& ", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+		return "", fmt.Errorf("%w: '%s'", ErrUnsupportedStartCommand, originalCommand)
+	}
 
-	newCmd := parts[0] + la.buildCommandLine(parts[1])
+	newCmd := parts[0] + la.buildCommandLine(parts[1], plan)
 
-	return newCmd
+	return newCmd, nil
 }
 
 // Get command line that will launch sealights agent with required options.
@@ -79,24 +286,41 @@ func (la *Launcher) updateStartCommand(originalCommand string) string {
 // SL.DotNet [verb] [options]
 // SL.DotNet [verb] [options] && source sealights.envrc && [start target app]
 // [customCommand]
-func (la *Launcher) buildCommandLine(command string) string {
+func (la *Launcher) buildCommandLine(command string, plan *LaunchPlan) string {
 	if la.Options.CustomCommand != "" {
 		return la.Options.CustomCommand
 	}
 
-	agentExecutable := la.agentFullPath()
-
 	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("%s %s", agentExecutable, la.Options.Verb))
+	sb.WriteString(la.agentCommandLine())
 
-	for key, value := range la.Options.SlArguments {
-		sb.WriteString(fmt.Sprintf(" --%s %s", key, value))
+	// listener started by the sidecar process, application only loads the profiler configuration
+	if la.Options.Verb == "startBackgroundTestListener" && la.Options.UseSidecar {
+		plan.SidecarCommand = sidecarCommand(sb.String())
+		return fmt.Sprintf("%s && %s", la.addProfilerConfiguration(plan), command)
+	}
+
+	// listener started and watched by the supervisor, which starts the application
+	// exec ./app --server.urls ... -> exec sl-supervisor ./app --server.urls ...
+	if la.Options.Verb == "startBackgroundTestListener" && la.Options.UseSupervisor {
+		stopArguments := la.agentArguments()
+		stopArguments[0] = AgentStopVerb
+
+		plan.Supervisor = &SupervisorConfig{
+			Command:            la.agentFullPath(),
+			Args:               la.agentArguments(),
+			StopArgs:           stopArguments,
+			Port:               la.Options.agentPort(),
+			GracePeriodSeconds: int(math.Ceil(la.Options.ShutdownGracePeriod.Seconds())),
+		}
+		supervisor := filepath.Join(la.AgentDirForRuntime, supervisorFileName())
+		return fmt.Sprintf("%s && exec %s %s", la.addProfilerConfiguration(plan), supervisor, strings.TrimPrefix(command, "exec "))
 	}
 
 	// background test listener require to set environment variables
 	// before starting the target process
 	if la.Options.Verb == "startBackgroundTestListener" {
-		exportEnvCmd, _ := la.addProfilerConfiguration(la.AgentDirForRuntime)
+		exportEnvCmd := la.addProfilerConfiguration(plan)
 
 		// if testListenerSessionKey is provided, selected mode is background test listener
 		// and target application should be started after the sealights agent
@@ -105,14 +329,46 @@ func (la *Launcher) buildCommandLine(command string) string {
 		// resulting launch command should have only one 'exec' keyword
 		// for the last subsequence part
 		return sb.String()
+	} else if runtime.GOOS == "windows" {
+		return sb.String()
 	} else {
 		return "exec " + sb.String()
 	}
 }
 
-// Create file sealights.envrc with all the required env variables to make
+// Get the verb and all the options as separate arguments. The agent listens on the default
+// port without the option, in the auto mode the port is added by the supervisor
+func (la *Launcher) agentArguments() []string {
+	arguments := []string{la.Options.Verb}
+	for key, value := range la.Options.SlArguments {
+		arguments = append(arguments, "--"+key, value)
+	}
+
+	if agentPort := la.Options.agentPort(); agentPort != DefaultPort && agentPort != AgentPortAuto {
+		arguments = append(arguments, "--"+AgentPortArgument, agentPort)
+	}
+
+	return arguments
+}
+
+// Get agent invocation with the verb and all the options:
+// SL.DotNet [verb] [options]
+func (la *Launcher) agentCommandLine() string {
+	agentExecutable := la.agentFullPath()
+
+	var sb strings.Builder
+	sb.WriteString(agentExecutable)
+
+	for _, argument := range la.agentArguments() {
+		sb.WriteString(" " + argument)
+	}
+
+	return sb.String()
+}
+
+// Plan file sealights.envrc with all the required env variables to make
 // the profiler to attach to the target application
-func (la *Launcher) addProfilerConfiguration(agentPath string) (string, error) {
+func (la *Launcher) addProfilerConfiguration(plan *LaunchPlan) string {
 	executeCommand := "source"
 	if runtime.GOOS == "windows" {
 		executeCommand = "call"
@@ -120,20 +376,23 @@ func (la *Launcher) addProfilerConfiguration(agentPath string) (string, error) {
 
 	agentEnvFileName := la.agentEnvFileName()
 
-	agentEnvFile := filepath.Join(la.AgentDirAbsolute, agentEnvFileName)
 	homeBasedEnvFile := filepath.Join(la.AgentDirForRuntime, agentEnvFileName)
 
-	envManager := NewEnvManager(la.Log, la.Options)
-	envVariebles := envManager.GetVariables(la.AgentDirForRuntime)
+	envManager := la.newEnvManager()
+	plan.AgentEnvFile = filepath.Join(la.AgentDirAbsolute, agentEnvFileName)
+	plan.AgentEnvVariables = envManager.GetVariables(la.AgentDirForRuntime)
 
-	err := envManager.WriteIntoFile(agentEnvFile, envVariebles)
-	if err != nil {
-		return "", err
-	}
+	return fmt.Sprintf("%s %s", executeCommand, homeBasedEnvFile)
+}
 
-	la.Log.Debug(fmt.Sprintf("Create file %s", agentEnvFileName))
+// Start command on windows is executed by cmd, so the home directory is taken
+// from the working directory which is the application root
+func runtimeHomeDir() string {
+	if runtime.GOOS == "windows" {
+		return "%CD%"
+	}
 
-	return fmt.Sprintf("%s %s", executeCommand, homeBasedEnvFile), nil
+	return "${HOME}"
 }
 
 func (la *Launcher) agentFullPath() string {
@@ -152,39 +411,84 @@ func (la *Launcher) agentEnvFileName() string {
 	}
 }
 
-func (la *Launcher) setEnvVariablesGlobally() {
-	envManager := NewEnvManager(la.Log, la.Options)
-	var envVariables map[string]string
-	if la.Options.UsePic {
+func (la *Launcher) planGlobalEnvVariables(plan *LaunchPlan) {
+	envManager := la.newEnvManager()
+	plan.GlobalEnvVariables = map[string]string{}
+	// start command of the hwc applications isn't modified, so the profiler is configured globally
+	isHwc := la.App != nil && la.App.Kind == AppKindNetFramework
+	if la.Options.UsePic || la.Options.usesExternalCollector() || isHwc {
 		// set all variables important for the profiler
-		envVariables = envManager.GetVariables(la.AgentDirForRuntime)
+		plan.GlobalEnvVariables = envManager.GetVariables(la.AgentDirForRuntime)
 	} else {
 		// set only dlls provided directly in options
-		envVariables = la.Options.SlArguments
+		for key, value := range la.Options.SlArguments {
+			plan.GlobalEnvVariables[key] = value
+		}
 	}
 
-	if runtime.GOOS == "windows" {
-		for key, value := range envVariables {
+	plan.GlobalEnvVariables[StagingReportEnvVariable] = filepath.Join(la.AgentDirForRuntime, StagingReportFileName)
+
+	if runtime.GOOS != "windows" {
+		plan.GlobalEnvFile = filepath.Join(la.AgentDirAbsolute, GlobalVariablesFile)
+		plan.ProfileDFile = filepath.Join(la.Stager.DepDir(), "profile.d", GlobalVariablesFile)
+	} else if isHwc {
+		plan.GlobalEnvFile = filepath.Join(la.AgentDirAbsolute, WindowsGlobalVariablesFile)
+		plan.ProfileDFile = filepath.Join(la.Stager.DepDir(), "profile.d", WindowsGlobalVariablesFile)
+	}
+}
+
+func (la *Launcher) setEnvVariablesGlobally(plan *LaunchPlan) error {
+	envManager := la.newEnvManager()
+
+	if plan.ProfileDFile == "" {
+		for key, value := range plan.GlobalEnvVariables {
 			os.Setenv(key, value)
 		}
-	} else {
-		localEnvFile := filepath.Join(la.AgentDirAbsolute, GlobalVariablesFile)
-		err := envManager.WriteIntoFile(localEnvFile, envVariables)
+
+		return nil
+	}
+
+	localEnvFile := plan.GlobalEnvFile
+
+	// listener is started before the profiler variables are set, otherwise the profiler would be attached to it
+	if plan.ListenerCommand != "" {
+		err := la.FileSystem.AppendFile(localEnvFile, []byte(listenerStartScript(plan.ListenerCommand)), 0644)
 		if err != nil {
-			la.Log.Error("Sealights. Failed to create local env file")
+			return fmt.Errorf("failed to create local env file: %w", err)
 		}
+	}
 
-		sealightsEnvPath := filepath.Join(la.Stager.DepDir(), "profile.d", GlobalVariablesFile)
-		la.Log.Debug("Copy %s to %s", localEnvFile, sealightsEnvPath)
-		if err = libbuildpack.CopyFile(localEnvFile, sealightsEnvPath); err != nil {
-			la.Log.Error("Sealights. Failed to copy file to profile.d")
-		}
+	err := envManager.WriteIntoFile(localEnvFile, plan.GlobalEnvVariables)
+	if err != nil {
+		return fmt.Errorf("failed to create local env file: %w", err)
+	}
+
+	sealightsEnvPath := plan.ProfileDFile
+	la.Log.Debug("Copy %s to %s", localEnvFile, sealightsEnvPath)
+	if err = copyFile(la.FileSystem, localEnvFile, sealightsEnvPath); err != nil {
+		return fmt.Errorf("failed to copy file to profile.d: %w", err)
 	}
+
+	return nil
 }
 
 func maskSensitiveData(input string) string {
-	re := regexp.MustCompile(`(--proxyPassword\s|--token\s).*?(\s--|\s&&)`)
-	output := re.ReplaceAllString(input, "$1********$2")
+	re := regexp.MustCompile(`(--proxyPassword\s|--token\s)\S+`)
+	output := re.ReplaceAllString(input, "$1********")
 
 	return output
 }
+
+// Get copy of the variables with secrets replaced by the mask
+func maskSensitiveVariables(variables map[string]string) map[string]string {
+	masked := make(map[string]string, len(variables))
+	for key, value := range variables {
+		lowerKey := strings.ToLower(key)
+		if strings.Contains(lowerKey, "token") || strings.Contains(lowerKey, "password") {
+			value = "********"
+		}
+		masked[key] = value
+	}
+
+	return masked
+}
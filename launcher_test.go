@@ -0,0 +1,106 @@
+package sealights_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sealights "github.com/Sealights/libbuildpack-sealights"
+	"github.com/Sealights/libbuildpack-sealights/sealightstest"
+)
+
+func newHwcStagingEnvironment(t *testing.T) *sealightstest.StagingEnvironment {
+	env := sealightstest.NewStagingEnvironment(t)
+	if err := os.Remove(filepath.Join(env.BuildDir, "tmp", sealights.ReleaseFileName)); err != nil {
+		t.Fatal(err)
+	}
+	env.WriteFile(filepath.Join(env.BuildDir, "Web.config"), "<configuration />")
+	// agent is installed before the start parameters are planned
+	if err := os.MkdirAll(env.AgentDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return env
+}
+
+func TestPlanStartParametersHwcApplication(t *testing.T) {
+	env := newHwcStagingEnvironment(t)
+
+	options := &sealights.SealightsOptions{
+		Verb:        "startBackgroundTestListener",
+		SlArguments: map[string]string{"tokenFile": "token.txt"},
+	}
+	stager := env.Stager()
+	launcher := sealights.NewLauncher(env.Log, options, sealights.AgentDir, stager)
+
+	steps := sealights.NewStagingSteps(env.Log, sealights.FailurePolicyFail)
+	plan, err := launcher.ModifyStartParameters(stager, steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plan.ShouldApply || plan.ReleaseInfo != nil {
+		t.Error("start command of the hwc application must not be modified")
+	}
+	if plan.OriginalCommand != sealights.HwcStartCommand {
+		t.Errorf("expected hwc start command, got '%s'", plan.OriginalCommand)
+	}
+	if plan.Application == nil || plan.Application.Kind != sealights.AppKindNetFramework {
+		t.Fatalf("expected %s application, got %+v", sealights.AppKindNetFramework, plan.Application)
+	}
+
+	env.AssertVariable(plan.GlobalEnvVariables, "Cor_Enable_Profiling", "1")
+	env.AssertVariable(plan.GlobalEnvVariables, "CORECLR_ENABLE_PROFILING", "")
+
+	// listener is started by the profile.d script before the profiler variables are set
+	script := env.ReadFile(plan.ProfileDFile)
+	listenerAt := strings.Index(script, "startBackgroundTestListener --tokenFile token.txt")
+	profilerAt := strings.Index(script, "Cor_Enable_Profiling=1")
+	if listenerAt < 0 || profilerAt < 0 || listenerAt > profilerAt {
+		t.Errorf("listener is expected to be started before the profiler is configured:\n%s", script)
+	}
+
+	if entries, _ := os.ReadDir(filepath.Join(env.BuildDir, "tmp")); len(entries) != 0 {
+		t.Errorf("no release file is expected, found %d files", len(entries))
+	}
+}
+
+func TestPlanStartParametersHwcApplicationCustomCommand(t *testing.T) {
+	tests := []struct {
+		policy      sealights.FailurePolicy
+		expectError bool
+	}{
+		{sealights.FailurePolicyFail, true},
+		{sealights.FailurePolicyWarn, false},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.policy), func(t *testing.T) {
+			env := newHwcStagingEnvironment(t)
+
+			options := &sealights.SealightsOptions{
+				Verb:          "startBackgroundTestListener",
+				CustomCommand: "run.bat",
+				SlArguments:   map[string]string{"tokenFile": "token.txt"},
+			}
+			stager := env.Stager()
+			launcher := sealights.NewLauncher(env.Log, options, sealights.AgentDir, stager)
+
+			steps := sealights.NewStagingSteps(env.Log, test.policy)
+			plan, err := launcher.ModifyStartParameters(stager, steps)
+
+			var stepError *sealights.StepError
+			if test.expectError != errors.As(err, &stepError) {
+				t.Fatalf("unexpected staging result: %v", err)
+			}
+			if plan != nil {
+				t.Errorf("start parameters must not be planned, got %+v", plan)
+			}
+			if !test.expectError && !steps.IsDegraded() {
+				t.Error("staging is expected to be degraded")
+			}
+		})
+	}
+}
@@ -0,0 +1,365 @@
+package sealights
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const NugetPackageBaseAddressType = "PackageBaseAddress/3.0.0"
+
+// NugetFeed resolves agent packages from NuGet v3 feed.
+// Service index is used to find the flat container (PackageBaseAddress)
+// resource which provides the list of versions and the package content
+type NugetFeed struct {
+	Log      *libbuildpack.Logger
+	Client   HTTPClient
+	IndexUrl string
+	Username string
+	Password string
+}
+
+type nugetServiceIndex struct {
+	Resources []struct {
+		Id   string `json:"@id"`
+		Type string `json:"@type"`
+	} `json:"resources"`
+}
+
+type nugetVersionsIndex struct {
+	Versions []string `json:"versions"`
+}
+
+func NewNugetFeed(log *libbuildpack.Logger, client HTTPClient, options *SealightsOptions) *NugetFeed {
+	return &NugetFeed{
+		Log:      log,
+		Client:   client,
+		IndexUrl: options.NugetFeed,
+		Username: options.NugetUsername,
+		Password: options.NugetPassword,
+	}
+}
+
+// ResolvePackageUrl returns download url of the package version matching the requested one.
+// Supported version formats: empty or 'latest' - the highest stable version,
+// exact version ('1.2.3') and NuGet range notation ('[1.0,2.0)', '(1.0,)', '[1.2.3]')
+func (feed *NugetFeed) ResolvePackageUrl(packageId string, versionRange string) (string, string, error) {
+	if packageId == "" {
+		return "", "", errors.New("nuget package id is not provided")
+	}
+
+	baseAddress, err := feed.getPackageBaseAddress()
+	if err != nil {
+		return "", "", err
+	}
+
+	lowerId := strings.ToLower(packageId)
+
+	var versionsIndex nugetVersionsIndex
+	err = feed.getJson(fmt.Sprintf("%s/%s/index.json", baseAddress, lowerId), &versionsIndex)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get versions of the package '%s': %w", packageId, err)
+	}
+
+	version, err := selectNugetVersion(versionsIndex.Versions, versionRange)
+	if err != nil {
+		return "", "", fmt.Errorf("package '%s': %w", packageId, err)
+	}
+
+	lowerVersion := strings.ToLower(version)
+
+	// resulting url example:
+	// https://api.nuget.org/v3-flatcontainer/package.id/1.2.3/package.id.1.2.3.nupkg
+	packageUrl := fmt.Sprintf("%s/%s/%s/%s.%s.nupkg", baseAddress, lowerId, lowerVersion, lowerId, lowerVersion)
+
+	return packageUrl, version, nil
+}
+
+// Authorize adds feed credentials to the request. Credentials are sent
+// only to the host of the feed to not leak them to the third parties
+func (feed *NugetFeed) Authorize(request *http.Request) {
+	if feed.Username == "" && feed.Password == "" {
+		return
+	}
+
+	feedUrl, err := url.Parse(feed.IndexUrl)
+	if err != nil || !strings.EqualFold(feedUrl.Host, request.URL.Host) {
+		return
+	}
+
+	request.SetBasicAuth(feed.Username, feed.Password)
+}
+
+func (feed *NugetFeed) getPackageBaseAddress() (string, error) {
+	var serviceIndex nugetServiceIndex
+	err := feed.getJson(feed.IndexUrl, &serviceIndex)
+	if err != nil {
+		return "", fmt.Errorf("failed to get nuget service index: %w", err)
+	}
+
+	for _, resource := range serviceIndex.Resources {
+		if resource.Type == NugetPackageBaseAddressType {
+			return strings.TrimSuffix(resource.Id, "/"), nil
+		}
+	}
+
+	return "", fmt.Errorf("nuget feed '%s' doesn't provide %s resource", feed.IndexUrl, NugetPackageBaseAddressType)
+}
+
+func (feed *NugetFeed) getJson(resourceUrl string, result interface{}) error {
+	feed.Log.Debug("Sealights. Request nuget resource '%s'", resourceUrl)
+
+	request, err := http.NewRequest(http.MethodGet, resourceUrl, nil)
+	if err != nil {
+		return err
+	}
+	feed.Authorize(request)
+
+	resp, err := feed.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response: %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+type nugetVersion struct {
+	Original   string
+	Numbers    []int
+	Prerelease string
+}
+
+type nugetVersionRange struct {
+	Min          *nugetVersion
+	Max          *nugetVersion
+	MinInclusive bool
+	MaxInclusive bool
+}
+
+func selectNugetVersion(versions []string, requested string) (string, error) {
+	requested = strings.TrimSpace(requested)
+
+	if requested != "" && requested != DefaultVersion && !strings.ContainsAny(requested, "[]()") {
+		// exact version requested
+		wanted, err := parseNugetVersion(requested)
+		if err != nil {
+			return "", err
+		}
+
+		for _, candidate := range versions {
+			version, err := parseNugetVersion(candidate)
+			if err == nil && compareNugetVersions(version, wanted) == 0 {
+				return candidate, nil
+			}
+		}
+
+		return "", fmt.Errorf("version '%s' is not found in the feed", requested)
+	}
+
+	versionRange := &nugetVersionRange{}
+	if requested != "" && requested != DefaultVersion {
+		var err error
+		versionRange, err = parseNugetVersionRange(requested)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// prerelease versions are selected only when asked explicitly by the range bound
+	allowPrerelease := versionRange.hasPrerelease()
+
+	var best *nugetVersion
+	for _, candidate := range versions {
+		version, err := parseNugetVersion(candidate)
+		if err != nil {
+			continue
+		}
+
+		if (version.Prerelease != "" && !allowPrerelease) || !versionRange.contains(version) {
+			continue
+		}
+
+		if best == nil || compareNugetVersions(version, best) > 0 {
+			best = version
+		}
+	}
+
+	if best == nil {
+		return "", fmt.Errorf("no version matching '%s' is found in the feed", requested)
+	}
+
+	return best.Original, nil
+}
+
+func parseNugetVersion(value string) (*nugetVersion, error) {
+	version := &nugetVersion{Original: value}
+
+	// build metadata is ignored by version comparison
+	value = strings.SplitN(value, "+", 2)[0]
+
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) == 2 {
+		version.Prerelease = parts[1]
+	}
+
+	for _, number := range strings.Split(parts[0], ".") {
+		parsed, err := strconv.Atoi(number)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid version '%s'", version.Original)
+		}
+		version.Numbers = append(version.Numbers, parsed)
+	}
+
+	return version, nil
+}
+
+func compareNugetVersions(left *nugetVersion, right *nugetVersion) int {
+	for i := 0; i < len(left.Numbers) || i < len(right.Numbers); i++ {
+		var l, r int
+		if i < len(left.Numbers) {
+			l = left.Numbers[i]
+		}
+		if i < len(right.Numbers) {
+			r = right.Numbers[i]
+		}
+
+		if l != r {
+			if l < r {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	// release version is higher than any prerelease one
+	switch {
+	case left.Prerelease == right.Prerelease:
+		return 0
+	case left.Prerelease == "":
+		return 1
+	case right.Prerelease == "":
+		return -1
+	default:
+		return comparePrereleaseLabels(left.Prerelease, right.Prerelease)
+	}
+}
+
+// Labels are compared by the dot separated identifiers as defined by SemVer 2.0:
+// numeric identifiers are compared numerically and are lower than alphanumeric ones,
+// a label with more identifiers is higher if all the preceding ones are equal
+func comparePrereleaseLabels(left string, right string) int {
+	leftIdentifiers := strings.Split(strings.ToLower(left), ".")
+	rightIdentifiers := strings.Split(strings.ToLower(right), ".")
+
+	for i := 0; i < len(leftIdentifiers) && i < len(rightIdentifiers); i++ {
+		l, r := leftIdentifiers[i], rightIdentifiers[i]
+		lNumber, lErr := strconv.ParseUint(l, 10, 64)
+		rNumber, rErr := strconv.ParseUint(r, 10, 64)
+
+		switch {
+		case lErr == nil && rErr == nil:
+			if lNumber != rNumber {
+				if lNumber < rNumber {
+					return -1
+				}
+				return 1
+			}
+		case lErr == nil:
+			return -1
+		case rErr == nil:
+			return 1
+		default:
+			if result := strings.Compare(l, r); result != 0 {
+				return result
+			}
+		}
+	}
+
+	switch {
+	case len(leftIdentifiers) < len(rightIdentifiers):
+		return -1
+	case len(leftIdentifiers) > len(rightIdentifiers):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func parseNugetVersionRange(value string) (*nugetVersionRange, error) {
+	if len(value) < 3 {
+		return nil, fmt.Errorf("invalid version range '%s'", value)
+	}
+
+	versionRange := &nugetVersionRange{
+		MinInclusive: value[0] == '[',
+		MaxInclusive: value[len(value)-1] == ']',
+	}
+
+	validStart := value[0] == '[' || value[0] == '('
+	validEnd := value[len(value)-1] == ']' || value[len(value)-1] == ')'
+	if !validStart || !validEnd {
+		return nil, fmt.Errorf("invalid version range '%s'", value)
+	}
+
+	bounds := strings.Split(value[1:len(value)-1], ",")
+	if len(bounds) > 2 {
+		return nil, fmt.Errorf("invalid version range '%s'", value)
+	}
+
+	var err error
+	if min := strings.TrimSpace(bounds[0]); min != "" {
+		if versionRange.Min, err = parseNugetVersion(min); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(bounds) == 1 {
+		// '[1.2.3]' means exact version
+		if !versionRange.MinInclusive || !versionRange.MaxInclusive || versionRange.Min == nil {
+			return nil, fmt.Errorf("invalid version range '%s'", value)
+		}
+		versionRange.Max = versionRange.Min
+		return versionRange, nil
+	}
+
+	if max := strings.TrimSpace(bounds[1]); max != "" {
+		if versionRange.Max, err = parseNugetVersion(max); err != nil {
+			return nil, err
+		}
+	}
+
+	return versionRange, nil
+}
+
+func (vr *nugetVersionRange) hasPrerelease() bool {
+	return (vr.Min != nil && vr.Min.Prerelease != "") || (vr.Max != nil && vr.Max.Prerelease != "")
+}
+
+func (vr *nugetVersionRange) contains(version *nugetVersion) bool {
+	if vr.Min != nil {
+		result := compareNugetVersions(version, vr.Min)
+		if result < 0 || (result == 0 && !vr.MinInclusive) {
+			return false
+		}
+	}
+
+	if vr.Max != nil {
+		result := compareNugetVersions(version, vr.Max)
+		if result > 0 || (result == 0 && !vr.MaxInclusive) {
+			return false
+		}
+	}
+
+	return true
+}
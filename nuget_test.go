@@ -0,0 +1,240 @@
+package sealights
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+var testNugetVersions = []string{"0.9.0", "1.0.0", "1.2.3", "1.5.0-beta", "1.5.0", "2.0.0-rc.1", "2.0.0", "2.1.0-preview",
+	"3.0.0-beta.2", "3.0.0-beta.10", "3.0.0-beta.9"}
+
+func TestSelectNugetVersion(t *testing.T) {
+	tests := []struct {
+		requested string
+		expected  string
+	}{
+		{"", "2.0.0"},
+		{"latest", "2.0.0"},
+		{"1.2.3", "1.2.3"},
+		{"1.5.0-beta", "1.5.0-beta"},
+		{"2.0.0-RC.1", "2.0.0-rc.1"},
+		{"[1.2.3]", "1.2.3"},
+		{"[1.0,2.0)", "1.5.0"},
+		{"[1.0,2.0]", "2.0.0"},
+		{"(1.0,1.2.3]", "1.2.3"},
+		{"[0.9,1.0)", "0.9.0"},
+		{"(,1.0]", "1.0.0"},
+		{"(1.5.0,)", "2.0.0"},
+		{"[1.5.0-alpha,1.5.0]", "1.5.0"},
+		{"[1.5.0-beta]", "1.5.0-beta"},
+		{"[1.0-alpha,1.5.0)", "1.5.0-beta"},
+		{"(2.0.0,2.1.0-preview]", "2.1.0-preview"},
+		{"[3.0.0-beta,3.0.0)", "3.0.0-beta.10"},
+		{"[3.0.0-beta.2,3.0.0-beta.9]", "3.0.0-beta.9"},
+	}
+
+	for _, test := range tests {
+		selected, err := selectNugetVersion(testNugetVersions, test.requested)
+		if err != nil {
+			t.Errorf("'%s': unexpected error %v", test.requested, err)
+			continue
+		}
+		if selected != test.expected {
+			t.Errorf("'%s': expected '%s', got '%s'", test.requested, test.expected, selected)
+		}
+	}
+}
+
+func TestSelectNugetVersionNotFound(t *testing.T) {
+	tests := []string{
+		"3.0.0",
+		"1.2",
+		"(1.0,1.2.3)",
+		"[2.1,)",
+		"[1.5.0-rc]",
+		"(2.0.0,2.1.0-preview)",
+	}
+
+	for _, requested := range tests {
+		if selected, err := selectNugetVersion(testNugetVersions, requested); err == nil {
+			t.Errorf("'%s': expected error, got '%s'", requested, selected)
+		}
+	}
+}
+
+func TestParseNugetVersionRange(t *testing.T) {
+	tests := []struct {
+		value        string
+		min          string
+		max          string
+		minInclusive bool
+		maxInclusive bool
+	}{
+		{"[1.0,2.0)", "1.0", "2.0", true, false},
+		{"(1.0,2.0]", "1.0", "2.0", false, true},
+		{"[1.2.3]", "1.2.3", "1.2.3", true, true},
+		{"(,2.0)", "", "2.0", false, false},
+		{"[1.0,)", "1.0", "", true, false},
+		{"[ 1.0 , 2.0 ]", "1.0", "2.0", true, true},
+	}
+
+	for _, test := range tests {
+		versionRange, err := parseNugetVersionRange(test.value)
+		if err != nil {
+			t.Errorf("'%s': unexpected error %v", test.value, err)
+			continue
+		}
+
+		if bound := versionString(versionRange.Min); bound != test.min {
+			t.Errorf("'%s': expected min '%s', got '%s'", test.value, test.min, bound)
+		}
+		if bound := versionString(versionRange.Max); bound != test.max {
+			t.Errorf("'%s': expected max '%s', got '%s'", test.value, test.max, bound)
+		}
+		if versionRange.MinInclusive != test.minInclusive || versionRange.MaxInclusive != test.maxInclusive {
+			t.Errorf("'%s': unexpected inclusiveness %v/%v", test.value, versionRange.MinInclusive, versionRange.MaxInclusive)
+		}
+	}
+}
+
+func TestParseNugetVersionRangeInvalid(t *testing.T) {
+	tests := []string{"", "[]", "[1.0", "1.0]", "{1.0,2.0}", "(1.2.3)", "[1.2.3)", "[,]x", "[1.0,2.0,3.0]", "[a.b,2.0]", "[1.0,-2]"}
+
+	for _, value := range tests {
+		if _, err := parseNugetVersionRange(value); err == nil {
+			t.Errorf("'%s': expected error", value)
+		}
+	}
+}
+
+func TestCompareNugetVersions(t *testing.T) {
+	tests := []struct {
+		left     string
+		right    string
+		expected int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0", "1.0.0.0", 0},
+		{"1.0.0+build.5", "1.0.0", 0},
+		{"1.0.1", "1.0.0", 1},
+		{"1.10.0", "1.9.0", 1},
+		{"1.0.0", "1.0.0-rc", 1},
+		{"1.0.0-alpha", "1.0.0-beta", -1},
+		{"1.0.0-BETA", "1.0.0-beta", 0},
+		{"2.0.0-alpha", "1.9.9", 1},
+		{"1.0.0-beta.10", "1.0.0-beta.2", 1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.1", "1.0.0-beta.a", -1},
+		{"1.0.0-beta", "1.0.0-beta.1", -1},
+		{"1.0.0-rc.1", "1.0.0-beta.9", 1},
+	}
+
+	for _, test := range tests {
+		left, err := parseNugetVersion(test.left)
+		if err != nil {
+			t.Fatal(err)
+		}
+		right, err := parseNugetVersion(test.right)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result := sign(compareNugetVersions(left, right)); result != test.expected {
+			t.Errorf("compare '%s' with '%s': expected %d, got %d", test.left, test.right, test.expected, result)
+		}
+	}
+}
+
+func TestNugetFeedAuthorize(t *testing.T) {
+	feed := &NugetFeed{IndexUrl: "https://nuget.example.com/v3/index.json", Username: "user", Password: "secret"}
+
+	tests := []struct {
+		url        string
+		authorized bool
+	}{
+		{"https://nuget.example.com/v3-flatcontainer/agent/index.json", true},
+		{"https://NUGET.example.com/v3-flatcontainer/agent/1.0.0/agent.1.0.0.nupkg", true},
+		{"https://cdn.example.com/packages/agent.1.0.0.nupkg", false},
+		{"https://nuget.example.com.evil.com/v3/index.json", false},
+		{"https://nuget.example.com:8443/v3/index.json", false},
+	}
+
+	for _, test := range tests {
+		request, err := http.NewRequest(http.MethodGet, test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		feed.Authorize(request)
+		_, _, authorized := request.BasicAuth()
+		if authorized != test.authorized {
+			t.Errorf("'%s': expected credentials sent %v, got %v", test.url, test.authorized, authorized)
+		}
+	}
+}
+
+func TestNugetFeedResolvePackageUrl(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, _ := r.BasicAuth(); username != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v3/index.json":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"resources": []map[string]string{
+					{"@id": server.URL + "/search", "@type": "SearchQueryService"},
+					{"@id": server.URL + "/v3-flatcontainer/", "@type": NugetPackageBaseAddressType},
+				},
+			})
+		case "/v3-flatcontainer/sealights.agent/index.json":
+			json.NewEncoder(w).Encode(map[string]interface{}{"versions": testNugetVersions})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	options := &SealightsOptions{NugetFeed: server.URL + "/v3/index.json", NugetUsername: "user", NugetPassword: "secret"}
+	feed := NewNugetFeed(libbuildpack.NewLogger(os.Stdout), server.Client(), options)
+
+	packageUrl, version, err := feed.ResolvePackageUrl("Sealights.Agent", "[1.0,2.0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := server.URL + "/v3-flatcontainer/sealights.agent/1.5.0/sealights.agent.1.5.0.nupkg"
+	if packageUrl != expected || version != "1.5.0" {
+		t.Errorf("expected '%s' (1.5.0), got '%s' (%s)", expected, packageUrl, version)
+	}
+
+	if _, _, err = feed.ResolvePackageUrl("Unknown.Package", ""); err == nil {
+		t.Error("expected error for unknown package")
+	}
+}
+
+func versionString(version *nugetVersion) string {
+	if version == nil {
+		return ""
+	}
+
+	return version.Original
+}
+
+func sign(value int) int {
+	switch {
+	case value < 0:
+		return -1
+	case value > 0:
+		return 1
+	default:
+		return 0
+	}
+}
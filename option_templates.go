@@ -0,0 +1,115 @@
+package sealights
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// TemplateData is available in the values of 'cli' and 'env' options:
+// "labId": "{{.SpaceName}}-{{.AppName}}", "buildName": "{{.Env.BUILD_NUMBER}}"
+// Instance fields are expanded when the instance starts, the rest at staging
+type TemplateData struct {
+	InstanceInfo
+	Env map[string]string
+}
+
+func NewTemplateData() (*TemplateData, error) {
+	info, err := NewInstanceInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TemplateData{InstanceInfo: *info, Env: templateEnvironment()}, nil
+}
+
+// ExpandOptionTemplates replaces templates in the values of the agent arguments and
+// env variables. Unknown fields and env variables are errors, values that can't be
+// expanded are removed, so the templates never reach the agent
+func ExpandOptionTemplates(options *SealightsOptions) error {
+	data, err := NewTemplateData()
+	if err != nil {
+		dropTemplates(options.SlArguments)
+		dropTemplates(options.BuildSessionArguments)
+		dropTemplates(options.SlEnvironment)
+		return err
+	}
+
+	var failures []string
+	failures = append(failures, data.expandValues("cli", options.SlArguments)...)
+	failures = append(failures, data.expandValues("buildSessionCli", options.BuildSessionArguments)...)
+	failures = append(failures, data.expandValues("env", options.SlEnvironment)...)
+	if len(failures) > 0 {
+		return fmt.Errorf("%s (the values are removed)", strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+// Expand every value with the template and remove the ones that fail
+func (data *TemplateData) expandValues(optionName string, values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var failures []string
+	for _, key := range keys {
+		if !isTemplate(values[key]) {
+			continue
+		}
+
+		expanded, err := data.expand(optionName+"."+key, values[key])
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("option '%s.%s': %v", optionName, key, err))
+			delete(values, key)
+			continue
+		}
+		values[key] = expanded
+	}
+
+	return failures
+}
+
+func dropTemplates(values map[string]string) {
+	for key, value := range values {
+		if isTemplate(value) {
+			delete(values, key)
+		}
+	}
+}
+
+func isTemplate(value string) bool {
+	return strings.Contains(value, "{{")
+}
+
+func (data *TemplateData) expand(name string, value string) (string, error) {
+	parsed, err := template.New(name).Option("missingkey=error").Parse(value)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	if err = parsed.Execute(&sb, data); err != nil {
+		return "", err
+	}
+
+	return sb.String(), nil
+}
+
+// Staging env variables except the service credentials
+func templateEnvironment() map[string]string {
+	environment := map[string]string{}
+	for _, variable := range os.Environ() {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) != 2 || parts[0] == "VCAP_SERVICES" {
+			continue
+		}
+		environment[parts[0]] = parts[1]
+	}
+
+	return environment
+}
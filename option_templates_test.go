@@ -0,0 +1,65 @@
+package sealights
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandOptionTemplates(t *testing.T) {
+	t.Setenv("VCAP_APPLICATION", `{"application_name":"orders","space_name":"dev"}`)
+	t.Setenv("BUILD_NUMBER", "42")
+
+	options := &SealightsOptions{
+		SlArguments:           map[string]string{"labId": "{{.SpaceName}}-{{.AppName}}", "tokenFile": "token.txt"},
+		BuildSessionArguments: map[string]string{"buildName": "{{.Env.BUILD_NUMBER}}"},
+		SlEnvironment:         map[string]string{"SL_LOG_LEVEL": "6"},
+	}
+
+	if err := ExpandOptionTemplates(options); err != nil {
+		t.Fatal(err)
+	}
+
+	if options.SlArguments["labId"] != "dev-orders" || options.SlArguments["tokenFile"] != "token.txt" {
+		t.Errorf("unexpected cli options: %v", options.SlArguments)
+	}
+	if options.BuildSessionArguments["buildName"] != "42" {
+		t.Errorf("unexpected build session options: %v", options.BuildSessionArguments)
+	}
+}
+
+func TestExpandOptionTemplatesRemovesFailedValues(t *testing.T) {
+	tests := []struct {
+		name            string
+		vcapApplication string
+	}{
+		{"unknown field", `{"application_name":"orders"}`},
+		{"invalid VCAP_APPLICATION", `{`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("VCAP_APPLICATION", test.vcapApplication)
+
+			options := &SealightsOptions{
+				SlArguments:           map[string]string{"labId": "{{.Unknown}}", "tokenFile": "token.txt"},
+				BuildSessionArguments: map[string]string{},
+				SlEnvironment:         map[string]string{"SL_LAB": "{{.Env.SL_MISSING_VARIABLE}}", "SL_LOG_LEVEL": "6"},
+			}
+
+			if err := ExpandOptionTemplates(options); err == nil {
+				t.Fatal("error is expected")
+			}
+
+			for _, values := range []map[string]string{options.SlArguments, options.SlEnvironment} {
+				for key, value := range values {
+					if strings.Contains(value, "{{") {
+						t.Errorf("template of '%s' is kept: %s", key, value)
+					}
+				}
+			}
+			if options.SlArguments["tokenFile"] != "token.txt" || options.SlEnvironment["SL_LOG_LEVEL"] != "6" {
+				t.Errorf("values without templates are expected to be kept: %v %v", options.SlArguments, options.SlEnvironment)
+			}
+		})
+	}
+}
@@ -1,14 +1,19 @@
 package sealights
 
 import (
+	"errors"
+	"fmt"
 	"path/filepath"
-
-	"github.com/cloudfoundry/libbuildpack"
 )
 
 const ReleaseFileName = "dotnet-core-buildpack-release-step.yml"
 const StartCommandType = "web"
 
+// HwcStartCommand is the static start command of the hwc buildpack, it is started in the application directory
+const HwcStartCommand = `.cloudfoundry\hwc.exe`
+
+var ErrStartCommandNotFound = errors.New("start command is not found in the release info")
+
 // file format:
 // default_process_types:
 //     web: cd ${DEPS_DIR}/0/dotnet_publish && exec ./app --server.urls http://0.0.0.0:${PORT}
@@ -17,14 +22,36 @@ type ReleaseData struct {
 }
 
 type ReleaseInfo struct {
-	Data     ReleaseData
-	FilePath string
+	Data       ReleaseData
+	FilePath   string
+	FileSystem FileSystem
 }
 
-func NewReleaseInfo(buildDirectory string) *ReleaseInfo {
+func NewReleaseInfo(fileSystem FileSystem, buildDirectory string) (*ReleaseInfo, error) {
 	releaseFilePath := filepath.Join(buildDirectory, "tmp", ReleaseFileName)
-	releaseData, _ := parseReleaseData(releaseFilePath)
-	return &ReleaseInfo{Data: releaseData, FilePath: releaseFilePath}
+
+	releaseData, err := parseReleaseData(fileSystem, releaseFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read release info '%s': %w", releaseFilePath, err)
+	}
+
+	releaseInfo := &ReleaseInfo{Data: releaseData, FilePath: releaseFilePath, FileSystem: fileSystem}
+	if releaseInfo.GetStartCommand() == "" {
+		return nil, fmt.Errorf("%w: '%s'", ErrStartCommandNotFound, releaseFilePath)
+	}
+
+	return releaseInfo, nil
+}
+
+// Release step of the dotnet core buildpack has priority, hwc is used only for the full framework applications.
+// The hwc buildpack has the static start command, so it can't be modified by the hook
+func isHwcRelease(fileSystem FileSystem, buildDirectory string) (bool, error) {
+	exists, err := fileExists(fileSystem, filepath.Join(buildDirectory, "tmp", ReleaseFileName))
+	if err != nil || exists {
+		return false, err
+	}
+
+	return isHwcApplication(fileSystem, buildDirectory)
 }
 
 func (rel *ReleaseInfo) GetStartCommand() string {
@@ -33,15 +60,24 @@ func (rel *ReleaseInfo) GetStartCommand() string {
 
 func (rel *ReleaseInfo) SetStartCommand(newCommand string) error {
 	rel.Data.DefaultProcessTypes[StartCommandType] = newCommand
-	return writeReleaseData(rel.FilePath, rel.Data)
+	return writeReleaseData(rel.FileSystem, rel.FilePath, rel.Data)
 }
 
-func parseReleaseData(releaseFilePath string) (ReleaseData, error) {
+func parseReleaseData(fileSystem FileSystem, releaseFilePath string) (ReleaseData, error) {
 	var releaseData ReleaseData
-	err := libbuildpack.NewYAML().Load(releaseFilePath, &releaseData)
+	err := readYaml(fileSystem, releaseFilePath, &releaseData)
 	return releaseData, err
 }
 
-func writeReleaseData(releaseFilePath string, releaseData ReleaseData) error {
-	return libbuildpack.NewYAML().Write(releaseFilePath, releaseData)
+// Write data into the temporary file first and replace the release file
+// only once it is completely written, so it never stays in a broken state
+func writeReleaseData(fileSystem FileSystem, releaseFilePath string, releaseData ReleaseData) error {
+	tempFilePath := releaseFilePath + ".sealights.tmp"
+	err := writeYaml(fileSystem, tempFilePath, releaseData)
+	if err != nil {
+		fileSystem.Remove(tempFilePath)
+		return err
+	}
+
+	return fileSystem.Rename(tempFilePath, releaseFilePath)
 }
@@ -0,0 +1,92 @@
+package sealights
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestReleaseFile(t *testing.T, content string) string {
+	buildDir := t.TempDir()
+	releaseFile := filepath.Join(buildDir, "tmp", ReleaseFileName)
+	if err := os.MkdirAll(filepath.Dir(releaseFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(releaseFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return buildDir
+}
+
+func TestNewReleaseInfoRequiresStartCommand(t *testing.T) {
+	if _, err := NewReleaseInfo(OsFileSystem{}, t.TempDir()); err == nil {
+		t.Error("missing release file is expected to be an error")
+	}
+
+	buildDir := writeTestReleaseFile(t, "default_process_types:\n  worker: ./worker\n")
+	if _, err := NewReleaseInfo(OsFileSystem{}, buildDir); !errors.Is(err, ErrStartCommandNotFound) {
+		t.Errorf("expected %v, got %v", ErrStartCommandNotFound, err)
+	}
+}
+
+func TestSetStartCommandReplacesReleaseFile(t *testing.T) {
+	buildDir := writeTestReleaseFile(t, "default_process_types:\n  web: cd ${DEPS_DIR}/0/dotnet_publish && exec ./app\n")
+
+	releaseInfo, err := NewReleaseInfo(OsFileSystem{}, buildDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = releaseInfo.SetStartCommand("cd ${DEPS_DIR}/0/dotnet_publish && exec ./other"); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := NewReleaseInfo(OsFileSystem{}, buildDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.GetStartCommand() != "cd ${DEPS_DIR}/0/dotnet_publish && exec ./other" {
+		t.Errorf("unexpected start command '%s'", updated.GetStartCommand())
+	}
+
+	entries, err := os.ReadDir(filepath.Join(buildDir, "tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary release file is expected to be renamed, found %d files", len(entries))
+	}
+}
+
+func TestUpdateStartCommandUnsupportedFormat(t *testing.T) {
+	launcher := &Launcher{Options: &SealightsOptions{Verb: "startBackgroundTestListener"}}
+	for _, command := range []string{"./app", "cd /app && "} {
+		if _, err := launcher.updateStartCommand(command, &LaunchPlan{}); !errors.Is(err, ErrUnsupportedStartCommand) {
+			t.Errorf("'%s': expected %v, got %v", command, ErrUnsupportedStartCommand, err)
+		}
+	}
+}
+
+func TestIsHwcRelease(t *testing.T) {
+	buildDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(buildDir, "Web.config"), []byte("<configuration />"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if isHwc, err := isHwcRelease(OsFileSystem{}, buildDir); err != nil || !isHwc {
+		t.Errorf("hwc release is expected for the full framework application (%v)", err)
+	}
+
+	// release step of the dotnet core buildpack has priority
+	releaseFile := filepath.Join(buildDir, "tmp", ReleaseFileName)
+	if err := os.MkdirAll(filepath.Dir(releaseFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(releaseFile, []byte("default_process_types: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if isHwc, err := isHwcRelease(OsFileSystem{}, buildDir); err != nil || isHwc {
+		t.Errorf("hwc release is not expected with the dotnet core release step (%v)", err)
+	}
+}
@@ -0,0 +1,12 @@
+#!/usr/bin/env bash
+# Build the Sealights Cloud Native Buildpack into cnb/ directory:
+#   ./scripts/package-cnb.sh && pack buildpack package sealights-dotnet --path cnb
+set -euo pipefail
+
+cd "$(dirname "$0")/.."
+
+mkdir -p cnb/bin
+GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -ldflags="-s -w" -o cnb/bin/main ./cmd/cnb
+
+ln -sf main cnb/bin/detect
+ln -sf main cnb/bin/build
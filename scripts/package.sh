@@ -0,0 +1,14 @@
+#!/usr/bin/env bash
+# Build the standalone Sealights supply buildpack:
+#   ./scripts/package.sh && cf create-buildpack sealights sealights_buildpack-v$(cat VERSION).zip 1
+set -euo pipefail
+
+cd "$(dirname "$0")/.."
+
+version="$(cat VERSION)"
+
+mkdir -p bin
+GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -ldflags="-s -w" -o bin/supply ./cmd/supply
+
+rm -f "sealights_buildpack-v${version}.zip"
+zip -r "sealights_buildpack-v${version}.zip" README.md VERSION manifest.yml bin/supply
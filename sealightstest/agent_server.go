@@ -0,0 +1,187 @@
+package sealightstest
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	sealights "github.com/Sealights/libbuildpack-sealights"
+)
+
+const DefaultAgentVersion = "1.0.0"
+const DefaultNugetPackageId = "Sealights.DotNet.Agent"
+
+// Package formats served by the AgentServer
+const (
+	PackageTarGz = "tar.gz"
+	PackageZip   = "zip"
+	PackageNupkg = "nupkg"
+)
+
+// AgentServer serves fake agent packages as tar.gz, zip and from the NuGet v3 feed
+// (the package content is placed into the 'content' directory like in the real nupkg)
+type AgentServer struct {
+	*httptest.Server
+
+	// Files of the agent package, path relative to the package root and content
+	Files          map[string]string
+	NugetPackageId string
+	NugetVersions  []string
+
+	mutex    sync.Mutex
+	requests []string
+}
+
+// NewAgentServer starts the server with DefaultAgentFiles, it's closed when the test ends
+func NewAgentServer(t testing.TB) *AgentServer {
+	server := &AgentServer{
+		Files:          DefaultAgentFiles(DefaultAgentVersion),
+		NugetPackageId: DefaultNugetPackageId,
+		NugetVersions:  []string{DefaultAgentVersion},
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// DefaultAgentFiles contains the agent executables, profiler libraries and the startup hook
+func DefaultAgentFiles(version string) map[string]string {
+	return map[string]string{
+		sealights.VersionFileName:         version + "\n",
+		sealights.LinuxAgentName:          "\x7fELF agent",
+		sealights.WindowsAgentName:        "MZ agent",
+		sealights.LinuxProfilerName:       "\x7fELF profiler",
+		sealights.WingowsProfilerName32:   "MZ profiler",
+		sealights.WingowsProfilerName64:   "MZ profiler",
+		sealights.StartupHookAssemblyName: "MZ startup hook",
+	}
+}
+
+// PackageUrl is the url of the package in the format, to be used as 'customAgentUrl'
+func (server *AgentServer) PackageUrl(format string) string {
+	return fmt.Sprintf("%s/agent/agent.%s", server.URL, format)
+}
+
+// NugetFeedUrl is the url of the service index, to be used as 'nugetFeed'
+func (server *AgentServer) NugetFeedUrl() string {
+	return server.URL + "/v3/index.json"
+}
+
+// Requests returns paths of the received requests
+func (server *AgentServer) Requests() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	return append([]string{}, server.requests...)
+}
+
+func (server *AgentServer) handle(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	server.requests = append(server.requests, r.URL.Path)
+	server.mutex.Unlock()
+
+	flatContainer := "/v3-flatcontainer/" + strings.ToLower(server.NugetPackageId) + "/"
+
+	switch {
+	case r.URL.Path == "/agent/agent."+PackageTarGz:
+		server.write(w, server.tarGz)
+	case r.URL.Path == "/agent/agent."+PackageZip:
+		server.write(w, func() ([]byte, error) { return server.zip("") })
+	case r.URL.Path == "/v3/index.json":
+		writeJson(w, map[string]interface{}{
+			"version": "3.0.0",
+			"resources": []map[string]string{
+				{"@id": server.URL + "/v3-flatcontainer/", "@type": sealights.NugetPackageBaseAddressType},
+			},
+		})
+	case r.URL.Path == flatContainer+"index.json":
+		writeJson(w, map[string]interface{}{"versions": server.NugetVersions})
+	case strings.HasPrefix(r.URL.Path, flatContainer) && strings.HasSuffix(r.URL.Path, "."+PackageNupkg):
+		server.write(w, func() ([]byte, error) { return server.zip("content/") })
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (server *AgentServer) write(w http.ResponseWriter, build func() ([]byte, error)) {
+	data, err := build()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
+
+func (server *AgentServer) tarGz() ([]byte, error) {
+	var buffer bytes.Buffer
+	gz := gzip.NewWriter(&buffer)
+	tw := tar.NewWriter(gz)
+
+	for _, name := range server.fileNames() {
+		content := server.Files[name]
+		header := &tar.Header{Name: name, Typeflag: tar.TypeReg, Size: int64(len(content)), Mode: 0644}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (server *AgentServer) zip(prefix string) ([]byte, error) {
+	var buffer bytes.Buffer
+	zw := zip.NewWriter(&buffer)
+
+	for _, name := range server.fileNames() {
+		fw, err := zw.Create(prefix + name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = fw.Write([]byte(server.Files[name])); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// sorted, so the packages are the same for every request
+func (server *AgentServer) fileNames() []string {
+	names := make([]string, 0, len(server.Files))
+	for name := range server.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func writeJson(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
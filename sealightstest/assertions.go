@@ -0,0 +1,109 @@
+package sealightstest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	sealights "github.com/Sealights/libbuildpack-sealights"
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// StartCommand reads the web command from the release step file
+func (env *StagingEnvironment) StartCommand() string {
+	env.T.Helper()
+
+	var releaseData sealights.ReleaseData
+	releaseFile := filepath.Join(env.BuildDir, "tmp", sealights.ReleaseFileName)
+	if err := libbuildpack.NewYAML().Load(releaseFile, &releaseData); err != nil {
+		env.T.Fatalf("failed to read release file: %v", err)
+	}
+
+	return releaseData.DefaultProcessTypes[sealights.StartCommandType]
+}
+
+// AgentEnv reads variables of the agent env file sourced by the start command
+func (env *StagingEnvironment) AgentEnv() map[string]string {
+	return env.ReadEnvFile(filepath.Join(env.AgentDir(), "sealights.envrc"))
+}
+
+// ProfileD reads variables of the profile.d script of the finalize phase
+func (env *StagingEnvironment) ProfileD() map[string]string {
+	return env.ReadEnvFile(filepath.Join(env.DepDir(), "profile.d", sealights.GlobalVariablesFile))
+}
+
+// ReadEnvFile parses 'export KEY=VALUE' lines, the rest of the script is ignored
+func (env *StagingEnvironment) ReadEnvFile(filePath string) map[string]string {
+	env.T.Helper()
+
+	variables := map[string]string{}
+	for _, line := range strings.Split(env.ReadFile(filePath), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "export ") {
+			continue
+		}
+
+		if key, value, found := strings.Cut(strings.TrimPrefix(line, "export "), "="); found {
+			variables[key] = value
+		}
+	}
+
+	return variables
+}
+
+func (env *StagingEnvironment) ReadFile(filePath string) string {
+	env.T.Helper()
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		env.T.Fatalf("failed to read '%s': %v", filePath, err)
+	}
+
+	return string(data)
+}
+
+func (env *StagingEnvironment) AssertFileExists(filePath string) {
+	env.T.Helper()
+
+	if _, err := os.Stat(filePath); err != nil {
+		env.T.Errorf("expected file '%s': %v", filePath, err)
+	}
+}
+
+func (env *StagingEnvironment) AssertStartCommandContains(expected string) {
+	env.T.Helper()
+
+	if command := env.StartCommand(); !strings.Contains(command, expected) {
+		env.T.Errorf("start command doesn't contain '%s': %s", expected, command)
+	}
+}
+
+func (env *StagingEnvironment) AssertStartCommandUnchanged() {
+	env.T.Helper()
+
+	if command := env.StartCommand(); command != DefaultStartCommand {
+		env.T.Errorf("start command is modified: %s", command)
+	}
+}
+
+// AssertVariable checks the variable in the parsed env file, empty expected value checks
+// that the variable is not set
+func (env *StagingEnvironment) AssertVariable(variables map[string]string, key string, expected string) {
+	env.T.Helper()
+
+	value, found := variables[key]
+	if expected == "" && found {
+		env.T.Errorf("variable '%s' is not expected, got '%s'", key, value)
+	} else if expected != "" && value != expected {
+		env.T.Errorf("variable '%s' = '%s', expected '%s'", key, value, expected)
+	}
+}
+
+// AssertLogContains checks the output of the hook
+func (env *StagingEnvironment) AssertLogContains(expected string) {
+	env.T.Helper()
+
+	if !strings.Contains(env.Output.String(), expected) {
+		env.T.Errorf("log doesn't contain '%s':\n%s", expected, env.Output.String())
+	}
+}
@@ -0,0 +1,124 @@
+// Package sealightstest provides utilities for the end-to-end testing of the hook:
+// temporary CF staging directories with the stager over them, fake agent server
+// and helpers to read the files the hook produces
+package sealightstest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sealights "github.com/Sealights/libbuildpack-sealights"
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const DefaultDepsIdx = "0"
+const DefaultBuildpackVersion = "1.0.0"
+
+// DefaultStartCommand is the start command of the dotnet-core buildpack release step
+const DefaultStartCommand = "cd ${DEPS_DIR}/0/dotnet_publish && exec ./app --server.urls http://0.0.0.0:${PORT}"
+
+// StagingEnvironment is the build, cache and deps layout of the staging container
+// created in the temporary directory of the test. Logs of the hook are written to Output
+type StagingEnvironment struct {
+	T            testing.TB
+	BuildDir     string
+	CacheDir     string
+	DepsDir      string
+	DepsIdx      string
+	BuildpackDir string
+	Output       *bytes.Buffer
+	Log          *libbuildpack.Logger
+}
+
+// NewStagingEnvironment creates directories of the staging and the buildpack manifest.
+// The release step file is created with DefaultStartCommand
+func NewStagingEnvironment(t testing.TB) *StagingEnvironment {
+	t.Helper()
+
+	root := t.TempDir()
+	output := &bytes.Buffer{}
+	env := &StagingEnvironment{
+		T:            t,
+		BuildDir:     filepath.Join(root, "build"),
+		CacheDir:     filepath.Join(root, "cache"),
+		DepsDir:      filepath.Join(root, "deps"),
+		DepsIdx:      DefaultDepsIdx,
+		BuildpackDir: filepath.Join(root, "buildpack"),
+		Output:       output,
+		Log:          libbuildpack.NewLogger(output),
+	}
+
+	for _, dir := range []string{env.BuildDir, env.CacheDir, env.DepDir(), env.BuildpackDir} {
+		env.mkdir(dir)
+	}
+
+	env.WriteFile(filepath.Join(env.BuildpackDir, "manifest.yml"), "language: dotnet-core\n")
+	env.WriteFile(filepath.Join(env.BuildpackDir, "VERSION"), DefaultBuildpackVersion)
+	env.WriteReleaseFile(DefaultStartCommand)
+
+	return env
+}
+
+// Stager returns libbuildpack stager over the staging directories
+func (env *StagingEnvironment) Stager() *libbuildpack.Stager {
+	env.T.Helper()
+
+	manifest, err := libbuildpack.NewManifest(env.BuildpackDir, env.Log, time.Now())
+	if err != nil {
+		env.T.Fatalf("failed to load buildpack manifest: %v", err)
+	}
+
+	return libbuildpack.NewStager([]string{env.BuildDir, env.CacheDir, env.DepsDir, env.DepsIdx}, env.Log, manifest)
+}
+
+// Hook returns the hook writing its logs to Output, the agent is downloaded by the default client
+func (env *StagingEnvironment) Hook() *sealights.SealightsHook {
+	return &sealights.SealightsHook{
+		Log:        env.Log,
+		Command:    &libbuildpack.Command{},
+		FileSystem: sealights.OsFileSystem{},
+	}
+}
+
+func (env *StagingEnvironment) DepDir() string {
+	return filepath.Join(env.DepsDir, env.DepsIdx)
+}
+
+// AgentDir is the agent installation directory of the finalize phase
+func (env *StagingEnvironment) AgentDir() string {
+	return filepath.Join(env.BuildDir, sealights.AgentDir)
+}
+
+// WriteReleaseFile replaces the release step file of the dotnet-core buildpack
+func (env *StagingEnvironment) WriteReleaseFile(startCommand string) {
+	env.T.Helper()
+
+	releaseData := sealights.ReleaseData{DefaultProcessTypes: map[string]string{sealights.StartCommandType: startCommand}}
+	releaseFile := filepath.Join(env.BuildDir, "tmp", sealights.ReleaseFileName)
+	env.mkdir(filepath.Dir(releaseFile))
+
+	if err := libbuildpack.NewYAML().Write(releaseFile, releaseData); err != nil {
+		env.T.Fatalf("failed to write release file: %v", err)
+	}
+}
+
+// WriteFile creates the file together with the parent directories
+func (env *StagingEnvironment) WriteFile(filePath string, content string) {
+	env.T.Helper()
+
+	env.mkdir(filepath.Dir(filePath))
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		env.T.Fatalf("failed to write '%s': %v", filePath, err)
+	}
+}
+
+func (env *StagingEnvironment) mkdir(dir string) {
+	env.T.Helper()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		env.T.Fatalf("failed to create '%s': %v", dir, err)
+	}
+}
@@ -0,0 +1,58 @@
+package sealightstest
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const DefaultServiceName = "sealights"
+
+// Credentials of the service, the same keys as in 'cf cups sealights -p'
+type Credentials map[string]interface{}
+
+// SetVcapServices binds user provided service 'sealights' with the credentials.
+// The variable is restored when the test ends
+func SetVcapServices(t testing.TB, credentials Credentials) {
+	SetVcapServicesWithName(t, "user-provided", DefaultServiceName, credentials)
+}
+
+// SetVcapServicesWithName binds the service of the label, e.g. a brokered service,
+// the hook selects the first one containing 'sealights' in its name
+func SetVcapServicesWithName(t testing.TB, label string, name string, credentials Credentials) {
+	t.Helper()
+
+	services := map[string][]map[string]interface{}{
+		label: {{"name": name, "label": label, "credentials": credentials}},
+	}
+
+	setJsonEnv(t, "VCAP_SERVICES", services)
+}
+
+// UnbindServices sets VCAP_SERVICES without any service, the hook does nothing then
+func UnbindServices(t testing.TB) {
+	t.Helper()
+	t.Setenv("VCAP_SERVICES", "{}")
+}
+
+// SetVcapApplication provides the application info used by the option templates
+func SetVcapApplication(t testing.TB, appName string, spaceName string, orgName string) {
+	t.Helper()
+
+	setJsonEnv(t, "VCAP_APPLICATION", map[string]string{
+		"application_name":  appName,
+		"application_id":    appName + "-id",
+		"space_name":        spaceName,
+		"organization_name": orgName,
+	})
+}
+
+func setJsonEnv(t testing.TB, name string, value interface{}) {
+	t.Helper()
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("failed to marshal %s: %v", name, err)
+	}
+
+	t.Setenv(name, string(data))
+}
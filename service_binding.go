@@ -0,0 +1,140 @@
+package sealights
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const ServiceBindingRootEnvVariable = "SERVICE_BINDING_ROOT"
+const SealightsBindingType = "sealights"
+
+// keys of the binding directory which describe the binding itself
+var bindingMetadataKeys = map[string]bool{
+	"type":     true,
+	"provider": true,
+}
+
+// Parse the servicebinding.io directory layout used on Kubernetes platforms:
+// $SERVICE_BINDING_ROOT/<name>/type       - 'sealights'
+// $SERVICE_BINDING_ROOT/<name>/provider   - optional
+// $SERVICE_BINDING_ROOT/<name>/<option>   - one file per option, the same keys as in VCAP_SERVICES credentials
+func (conf *Configuration) parseServiceBindings(root string) error {
+	bindingDir, err := findSealightsBinding(root)
+	if err != nil || bindingDir == "" {
+		return err
+	}
+
+	name := filepath.Base(bindingDir)
+
+	provider, err := readBindingValue(bindingDir, "provider")
+	if err == nil && provider != "" {
+		conf.Log.Debug("Sealights. Service binding '%s' is provided by '%s'", name, provider)
+	}
+
+	credentials, err := readBindingCredentials(bindingDir)
+	if err != nil {
+		return fmt.Errorf("service binding '%s': %w", name, err)
+	}
+
+	conf.Value = conf.parseCredentials(credentials)
+	conf.ServiceName = name
+	return nil
+}
+
+// Get directory of the first binding of 'sealights' type. Bindings are checked in the name order
+func findSealightsBinding(root string) (string, error) {
+	if root == "" {
+		return "", nil
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	for _, entry := range entries {
+		bindingDir := filepath.Join(root, entry.Name())
+		if info, err := os.Stat(bindingDir); err != nil || !info.IsDir() {
+			continue
+		}
+
+		bindingType, err := readBindingValue(bindingDir, "type")
+		if err != nil || !strings.EqualFold(bindingType, SealightsBindingType) {
+			continue
+		}
+
+		return bindingDir, nil
+	}
+
+	return "", nil
+}
+
+// Convert binding files into the same structure as VCAP_SERVICES credentials,
+// so both sources pass the same validation
+func readBindingCredentials(bindingDir string) (map[string]interface{}, error) {
+	files, err := os.ReadDir(bindingDir)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := map[string]interface{}{}
+	for _, file := range files {
+		key := file.Name()
+
+		// kubernetes projects secrets with hidden '..data' directories and symlinks to them
+		if strings.HasPrefix(key, ".") || bindingMetadataKeys[key] {
+			continue
+		}
+
+		if info, err := os.Stat(filepath.Join(bindingDir, key)); err != nil || info.IsDir() {
+			continue
+		}
+
+		value, err := readBindingValue(bindingDir, key)
+		if err != nil {
+			return nil, err
+		}
+
+		switch key {
+		case "cli", "env", "buildSessionCli":
+			var nested map[string]interface{}
+			if err := json.Unmarshal([]byte(value), &nested); err != nil {
+				return nil, fmt.Errorf("option '%s' should contain json object: %w", key, err)
+			}
+
+			for nestedKey, nestedValue := range nested {
+				if _, isString := nestedValue.(string); !isString {
+					return nil, fmt.Errorf("option '%s.%s' should be a string", key, nestedKey)
+				}
+			}
+
+			credentials[key] = nested
+		case "usePic", "useSidecar", "useSupervisor":
+			flag, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("option '%s' should contain boolean: %w", key, err)
+			}
+			credentials[key] = flag
+		default:
+			credentials[key] = value
+		}
+	}
+
+	return credentials, nil
+}
+
+func readBindingValue(bindingDir string, key string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(bindingDir, key))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,80 @@
+package sealights
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTestBinding(t *testing.T, root string, name string, files map[string]string) string {
+	bindingDir := filepath.Join(root, name)
+	if err := os.MkdirAll(bindingDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range files {
+		if err := os.WriteFile(filepath.Join(bindingDir, key), []byte(value), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return bindingDir
+}
+
+func TestFindSealightsBinding(t *testing.T) {
+	root := t.TempDir()
+	writeTestBinding(t, root, "a-database", map[string]string{"type": "postgresql"})
+	expected := writeTestBinding(t, root, "b-sealights", map[string]string{"type": "Sealights\n"})
+	writeTestBinding(t, root, "c-sealights", map[string]string{"type": "sealights"})
+
+	bindingDir, err := findSealightsBinding(root)
+	if err != nil || bindingDir != expected {
+		t.Errorf("expected '%s', got '%s' (%v)", expected, bindingDir, err)
+	}
+
+	if bindingDir, err = findSealightsBinding(filepath.Join(root, "missing")); err != nil || bindingDir != "" {
+		t.Errorf("no binding is expected without the root, got '%s' (%v)", bindingDir, err)
+	}
+}
+
+func TestReadBindingCredentials(t *testing.T) {
+	bindingDir := writeTestBinding(t, t.TempDir(), "sealights", map[string]string{
+		"type":     "sealights",
+		"provider": "sealights-broker",
+		"token":    "secret-token\n",
+		"usePic":   "true",
+		"cli":      `{"labId":"lab"}`,
+	})
+	if err := os.Mkdir(filepath.Join(bindingDir, "..data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	credentials, err := readBindingCredentials(bindingDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"token":  "secret-token",
+		"usePic": true,
+		"cli":    map[string]interface{}{"labId": "lab"},
+	}
+	if !reflect.DeepEqual(credentials, expected) {
+		t.Errorf("expected %v, got %v", expected, credentials)
+	}
+}
+
+func TestReadBindingCredentialsInvalid(t *testing.T) {
+	tests := map[string]string{
+		"cli":    `{"labId":1}`,
+		"env":    "SL_LOG_LEVEL=6",
+		"usePic": "sometimes",
+	}
+
+	for key, value := range tests {
+		bindingDir := writeTestBinding(t, t.TempDir(), "sealights", map[string]string{"type": "sealights", key: value})
+		if _, err := readBindingCredentials(bindingDir); err == nil {
+			t.Errorf("'%s': error is expected for '%s'", key, value)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package sealights
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const LaunchFileName = "launch.yml"
+const SidecarProcessType = "sealights-listener"
+
+// launch.yml of the dependency directory, the platform adds its processes to the droplet:
+// processes:
+// - type: sealights-listener
+//   command: unset DOTNET_STARTUP_HOOKS CORECLR_ENABLE_PROFILING ... && ${HOME}/sealights/SL.DotNet startBackgroundTestListener ...
+//   platforms:
+//     cloudfoundry:
+//       sidecar_for: [web]
+
+type launchData struct {
+	Processes []launchProcess `yaml:"processes"`
+}
+
+type launchProcess struct {
+	Type      string                 `yaml:"type"`
+	Command   string                 `yaml:"command"`
+	Limits    map[string]interface{} `yaml:"limits,omitempty"`
+	Platforms launchPlatforms        `yaml:"platforms"`
+}
+
+type launchPlatforms struct {
+	Cloudfoundry struct {
+		SidecarFor []string `yaml:"sidecar_for"`
+	} `yaml:"cloudfoundry"`
+}
+
+// Sidecar process runs the listener separately from the application. The agent
+// detaches the listener, so the process stays alive to not crash the instance.
+// Profile.d scripts are sourced for the sidecar as well, so the profiler and
+// the startup hook are unset to not be attached to the agent itself
+func sidecarCommand(listenerCommand string) string {
+	variables := append([]string{StartupHooksEnvVariable}, profilerVariables...)
+	return fmt.Sprintf("unset %s && %s && exec sleep infinity", strings.Join(variables, " "), listenerCommand)
+}
+
+// Register the sidecar in launch.yml of the dependency directory. Processes
+// already defined in the file are kept, the sealights one is replaced
+func writeSidecar(fileSystem FileSystem, depDir string, command string) error {
+	launchFile := filepath.Join(depDir, LaunchFileName)
+
+	var launch launchData
+	exists, err := fileExists(fileSystem, launchFile)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err = readYaml(fileSystem, launchFile, &launch); err != nil {
+			return fmt.Errorf("failed to read '%s': %w", launchFile, err)
+		}
+	}
+
+	sidecar := launchProcess{Type: SidecarProcessType, Command: command}
+	sidecar.Platforms.Cloudfoundry.SidecarFor = []string{StartCommandType}
+
+	var processes []launchProcess
+	for _, process := range launch.Processes {
+		if process.Type != SidecarProcessType {
+			processes = append(processes, process)
+		}
+	}
+	launch.Processes = append(processes, sidecar)
+
+	return writeYaml(fileSystem, launchFile, launch)
+}
@@ -0,0 +1,71 @@
+package sealights
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+func TestSidecarCommandUnsetsProfiler(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sidecars are started by the shell on linux")
+	}
+
+	// the listener prints its environment instead of starting, sleep is replaced to finish the command
+	command := strings.Replace(sidecarCommand("env"), "exec sleep infinity", "true", 1)
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = []string{"PATH=/usr/bin:/bin", StartupHooksEnvVariable + "=/app/hook.dll", "SL_AGENT_OPTION=1"}
+	for _, name := range profilerVariables {
+		cmd.Env = append(cmd.Env, name+"=1")
+	}
+
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		name := strings.SplitN(line, "=", 2)[0]
+		if isProfilerVariable(name) || name == StartupHooksEnvVariable {
+			t.Errorf("variable '%s' is passed to the listener", name)
+		}
+	}
+	if !strings.Contains(string(output), "SL_AGENT_OPTION=1") {
+		t.Error("other variables are expected to be kept")
+	}
+}
+
+func TestWriteSidecarKeepsOtherProcesses(t *testing.T) {
+	depDir := t.TempDir()
+	launchFile := filepath.Join(depDir, LaunchFileName)
+	existing := "processes:\n- type: other\n  command: ./other\n- type: sealights-listener\n  command: stale\n"
+	if err := os.WriteFile(launchFile, []byte(existing), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeSidecar(OsFileSystem{}, depDir, sidecarCommand("./listener")); err != nil {
+		t.Fatal(err)
+	}
+
+	var launch launchData
+	if err := libbuildpack.NewYAML().Load(launchFile, &launch); err != nil {
+		t.Fatal(err)
+	}
+	if len(launch.Processes) != 2 || launch.Processes[0].Type != "other" {
+		t.Fatalf("unexpected processes: %+v", launch.Processes)
+	}
+
+	sidecar := launch.Processes[1]
+	if sidecar.Type != SidecarProcessType || sidecar.Command != sidecarCommand("./listener") {
+		t.Errorf("unexpected sidecar process: %+v", sidecar)
+	}
+	if sidecarFor := sidecar.Platforms.Cloudfoundry.SidecarFor; len(sidecarFor) != 1 || sidecarFor[0] != StartCommandType {
+		t.Errorf("sidecar is expected for the web process, got %v", sidecarFor)
+	}
+}
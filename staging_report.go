@@ -0,0 +1,111 @@
+package sealights
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+)
+
+const StagingReportFileName = "staging-report.json"
+const StagingReportEnvVariable = "SL_STAGING_REPORT"
+
+// StagingReport describes how the droplet was instrumented. It is written into
+// the agent directory and its location is exposed to the application at runtime
+type StagingReport struct {
+	AgentVersion         string               `json:"agentVersion"`
+	AgentUrl             string               `json:"agentUrl"`
+	AgentSha256          string               `json:"agentSha256"`
+	ServiceName          string               `json:"serviceName"`
+	BuildpackVersion     string               `json:"buildpackVersion"`
+	Options              StagingReportOptions `json:"options"`
+	OriginalStartCommand string               `json:"originalStartCommand,omitempty"`
+	StartCommand         string               `json:"startCommand,omitempty"`
+	SidecarCommand       string               `json:"sidecarCommand,omitempty"`
+	ListenerCommand      string               `json:"listenerCommand,omitempty"`
+	Application          *AppInfo             `json:"application,omitempty"`
+	DegradedSteps        []DegradedStep       `json:"degradedSteps,omitempty"`
+}
+
+// StagingReportOptions is the effective configuration with secrets masked
+type StagingReportOptions struct {
+	Version             string              `json:"version,omitempty"`
+	Verb                string              `json:"verb,omitempty"`
+	CustomAgentUrl      string              `json:"customAgentUrl,omitempty"`
+	CustomCommand       string              `json:"customCommand,omitempty"`
+	Proxy               string              `json:"proxy,omitempty"`
+	UsePic              bool                `json:"usePic"`
+	UseSidecar          bool                `json:"useSidecar"`
+	UseSupervisor       bool                `json:"useSupervisor"`
+	AgentPort           string              `json:"agentPort,omitempty"`
+	CollectorUrl        string              `json:"collectorUrl,omitempty"`
+	CollectorId         string              `json:"collectorId,omitempty"`
+	ShutdownGracePeriod string              `json:"shutdownGracePeriod,omitempty"`
+	InstanceTag         string              `json:"instanceTag,omitempty"`
+	BuildSessionVerb    string              `json:"buildSessionVerb,omitempty"`
+	NugetFeed           string              `json:"nugetFeed,omitempty"`
+	NugetPackageId      string              `json:"nugetPackageId,omitempty"`
+	FailurePolicy       FailurePolicy       `json:"failurePolicy"`
+	InstrumentationMode InstrumentationMode `json:"instrumentationMode"`
+	SlArguments         map[string]string   `json:"cli"`
+	SlEnvironment       map[string]string   `json:"env"`
+}
+
+func NewStagingReport(conf *Configuration, agentInstaller *AgentInstaller, agentVersion string, plan *LaunchPlan, steps *StagingSteps) *StagingReport {
+	options := conf.Value
+
+	report := &StagingReport{
+		AgentVersion:     agentVersion,
+		AgentUrl:         agentInstaller.PackageUrl,
+		AgentSha256:      agentInstaller.PackageSha256,
+		ServiceName:      conf.ServiceName,
+		BuildpackVersion: conf.buildToolName(),
+		Options: StagingReportOptions{
+			Version:             options.Version,
+			Verb:                options.Verb,
+			CustomAgentUrl:      options.CustomAgentUrl,
+			CustomCommand:       maskSensitiveData(options.CustomCommand),
+			Proxy:               options.Proxy,
+			UsePic:              options.UsePic,
+			UseSidecar:          options.UseSidecar,
+			UseSupervisor:       options.UseSupervisor,
+			AgentPort:           options.AgentPort,
+			CollectorUrl:        options.CollectorUrl,
+			CollectorId:         options.CollectorId,
+			ShutdownGracePeriod: options.ShutdownGracePeriod.String(),
+			InstanceTag:         options.InstanceTag,
+			BuildSessionVerb:    options.BuildSessionVerb,
+			NugetFeed:           options.NugetFeed,
+			NugetPackageId:      options.NugetPackageId,
+			FailurePolicy:       options.FailurePolicy,
+			InstrumentationMode: options.InstrumentationMode,
+			SlArguments:         maskSensitiveVariables(options.SlArguments),
+			SlEnvironment:       maskSensitiveVariables(options.SlEnvironment),
+		},
+		DegradedSteps: steps.Degraded,
+	}
+
+	if plan != nil {
+		report.Application = plan.Application
+		report.ListenerCommand = maskSensitiveData(plan.ListenerCommand)
+	}
+
+	if plan != nil && plan.ShouldApply {
+		report.OriginalStartCommand = plan.OriginalCommand
+		report.StartCommand = maskSensitiveData(plan.StartCommand)
+		report.SidecarCommand = maskSensitiveData(plan.SidecarCommand)
+	}
+
+	return report
+}
+
+func (report *StagingReport) Write(fileSystem FileSystem, agentDirAbsolute string) error {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+
+	return fileSystem.WriteFile(filepath.Join(agentDirAbsolute, StagingReportFileName), buffer.Bytes(), 0644)
+}
@@ -0,0 +1,79 @@
+package sealights
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+func newTestReportConfiguration(t *testing.T, log *libbuildpack.Logger, options *SealightsOptions) *Configuration {
+	buildpackDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(buildpackDir, "manifest.yml"), []byte("---\nlanguage: dotnet-core\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(buildpackDir, "VERSION"), []byte("1.2.3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := libbuildpack.NewManifest(buildpackDir, log, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stager := libbuildpack.NewStager([]string{t.TempDir(), t.TempDir(), t.TempDir(), "0"}, log, manifest)
+
+	return &Configuration{Log: log, Stager: stager, Value: options, ServiceName: "sealights"}
+}
+
+func TestStagingReportMasksSecrets(t *testing.T) {
+	log := libbuildpack.NewLogger(&bytes.Buffer{})
+	options := &SealightsOptions{
+		Verb:          "startBackgroundTestListener",
+		FailurePolicy: FailurePolicyWarn,
+		SlArguments:   map[string]string{"token": "secret-token", "labId": "lab"},
+		SlEnvironment: map[string]string{},
+	}
+	conf := newTestReportConfiguration(t, log, options)
+	agentInstaller := &AgentInstaller{PackageUrl: "https://agents.example.com/agent.tar.gz", PackageSha256: "abc"}
+	plan := &LaunchPlan{
+		ShouldApply:     true,
+		OriginalCommand: "cd /app && exec ./app",
+		StartCommand:    "cd /app && SL.DotNet startBackgroundTestListener --token secret-token && exec ./app",
+	}
+	steps := NewStagingSteps(log, options.FailurePolicy)
+	steps.Skip(StepWriteStagingReport, "test")
+
+	report := NewStagingReport(conf, agentInstaller, "1.0.0", plan, steps)
+
+	agentDir := t.TempDir()
+	if err := report.Write(OsFileSystem{}, agentDir); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(agentDir, StagingReportFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "secret-token") {
+		t.Errorf("token is expected to be masked:\n%s", content)
+	}
+
+	var written StagingReport
+	if err = json.Unmarshal(content, &written); err != nil {
+		t.Fatal(err)
+	}
+	if written.AgentVersion != "1.0.0" || written.AgentSha256 != "abc" || written.ServiceName != "sealights" {
+		t.Errorf("unexpected report: %+v", written)
+	}
+	if written.Options.SlArguments["labId"] != "lab" || len(written.DegradedSteps) != 1 {
+		t.Errorf("unexpected report options: %+v", written)
+	}
+	if written.OriginalStartCommand != plan.OriginalCommand {
+		t.Errorf("unexpected original start command '%s'", written.OriginalStartCommand)
+	}
+}
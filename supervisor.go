@@ -0,0 +1,415 @@
+package sealights
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const SupervisorBinaryName = "sl-supervisor"
+const SupervisorConfigFileName = "supervisor.json"
+
+// AgentStopVerb makes the listener to flush collected footprints and exit
+const AgentStopVerb = "stopBackgroundTestListener"
+
+const DefaultShutdownGracePeriod = 5 * time.Second
+
+var ErrSupervisorNotFound = errors.New("buildpack executable is not found")
+
+// supervisorSource returns the binary installed as the supervisor, it is replaced in the tests
+var supervisorSource = os.Executable
+
+var forwardedSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT}
+var shutdownSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
+
+// SupervisorConfig is stored next to the supervisor binary at staging
+type SupervisorConfig struct {
+	Command            string   `json:"command"`
+	Args               []string `json:"args"`
+	StopArgs           []string `json:"stopArgs"`
+	Port               string   `json:"port"`
+	GracePeriodSeconds int      `json:"gracePeriodSeconds"`
+}
+
+// Supervisor keeps the background test listener running while the application works.
+// The listener is considered healthy while it accepts connections on the agent port,
+// otherwise it is started again with the exponential backoff
+type Supervisor struct {
+	Log           *libbuildpack.Logger
+	Config        SupervisorConfig
+	ReadyTimeout  time.Duration
+	CheckInterval time.Duration
+	MinBackoff    time.Duration
+	MaxBackoff    time.Duration
+}
+
+func NewSupervisor(log *libbuildpack.Logger, config SupervisorConfig) *Supervisor {
+	return &Supervisor{
+		Log:           log,
+		Config:        config,
+		ReadyTimeout:  30 * time.Second,
+		CheckInterval: 10 * time.Second,
+		MinBackoff:    time.Second,
+		MaxBackoff:    time.Minute,
+	}
+}
+
+// The supervisor is the buildpack executable installed into the droplet under the
+// supervisor name (the same way the CNB binary is used as exec.d), so it's available
+// in any buildpack the hook is built into without shipping a separate binary
+func init() {
+	if isSupervisorInvocation(os.Args[0]) {
+		os.Exit(RunSupervisor(libbuildpack.NewLogger(os.Stderr), os.Args[1:]))
+	}
+}
+
+func isSupervisorInvocation(executable string) bool {
+	return strings.TrimSuffix(filepath.Base(executable), ".exe") == SupervisorBinaryName
+}
+
+// RunSupervisor is the entrypoint of the supervisor binary:
+// sl-supervisor <application command> [arguments]
+// Returns exit code of the application
+func RunSupervisor(log *libbuildpack.Logger, appCommand []string) int {
+	if len(appCommand) == 0 {
+		log.Error("Usage: %s <application command> [arguments]", SupervisorBinaryName)
+		return 2
+	}
+
+	config, err := readSupervisorConfig()
+	if err != nil {
+		// the application is started anyway, it just works without the listener
+		log.Warning("Sealights. Failed to read supervisor configuration: %v", err)
+		return runApplication(log, appCommand, nil, nil)
+	}
+
+	return NewSupervisor(log, *config).Run(appCommand)
+}
+
+// Run starts the listener, waits until it is ready and starts the application.
+// Signals are forwarded to the application, the listener is checked until the application exits
+func (sv *Supervisor) Run(appCommand []string) int {
+	// the command isn't started by the shell, so the runtime paths are expanded here
+	sv.Config.Command = expandInstanceVariables([]string{sv.Config.Command})[0]
+	sv.Config.Args = expandInstanceVariables(sv.Config.Args)
+	sv.Config.StopArgs = expandInstanceVariables(sv.Config.StopArgs)
+
+	var appEnv []string
+	if sv.Config.Port == AgentPortAuto {
+		port, err := pickFreePort()
+		if err != nil {
+			sv.Log.Warning("Sealights. Failed to select agent port, continue with '%s': %v", DefaultPort, err)
+			port = DefaultPort
+		}
+
+		sv.Log.Info("Sealights. Agent port: %s", port)
+		sv.Config.Port = port
+		sv.Config.Args = append(sv.Config.Args, "--"+AgentPortArgument, port)
+		sv.Config.StopArgs = append(sv.Config.StopArgs, "--"+AgentPortArgument, port)
+
+		// profiler of the application connects to the same port
+		os.Setenv(AgentPortEnvVariable, port)
+		appEnv = os.Environ()
+	}
+
+	sv.startAgent()
+	if !sv.waitReady() {
+		sv.Log.Warning("Sealights. Background test listener isn't ready on port %s, starting the application anyway", sv.Config.Port)
+	}
+
+	stop := make(chan struct{})
+	var stopOnce, flushOnce sync.Once
+	stopMonitor := func() { stopOnce.Do(func() { close(stop) }) }
+	defer stopMonitor()
+	go sv.monitor(stop)
+
+	// the listener isn't restarted after the shutdown is requested
+	flushAgent := func() {
+		flushOnce.Do(func() {
+			stopMonitor()
+			sv.flushAgent()
+		})
+	}
+
+	return runApplication(sv.Log, appCommand, appEnv, flushAgent)
+}
+
+// Environment of the supervisor is inherited if env is nil. Before the shutdown
+// signal is forwarded to the application, beforeShutdown is called if provided
+func runApplication(log *libbuildpack.Logger, appCommand []string, env []string, beforeShutdown func()) int {
+	app := exec.Command(appCommand[0], appCommand[1:]...)
+	app.Env = env
+	app.Stdin = os.Stdin
+	app.Stdout = os.Stdout
+	app.Stderr = os.Stderr
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	if err := app.Start(); err != nil {
+		log.Error("Sealights. Failed to start the application: %v", err)
+		return 127
+	}
+
+	appDone := make(chan error, 1)
+	go func() {
+		appDone <- app.Wait()
+	}()
+
+	for {
+		select {
+		case sig := <-signals:
+			if beforeShutdown != nil && isShutdownSignal(sig) {
+				beforeShutdown()
+			}
+
+			if err := app.Process.Signal(sig); err != nil {
+				log.Warning("Sealights. Failed to forward signal '%s' to the application: %v", sig, err)
+			}
+		case err := <-appDone:
+			return exitCode(err)
+		}
+	}
+}
+
+// Check the listener periodically and restart it when the port is not reachable
+func (sv *Supervisor) monitor(stop <-chan struct{}) {
+	backoff := sv.MinBackoff
+	ticker := time.NewTicker(sv.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if sv.isReady() {
+			backoff = sv.MinBackoff
+			continue
+		}
+
+		sv.Log.Warning("Sealights. Background test listener isn't responding on port %s, restarting in %s", sv.Config.Port, backoff)
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+
+		sv.startAgent()
+		if sv.waitReady() {
+			sv.Log.Info("Sealights. Background test listener is restarted")
+			backoff = sv.MinBackoff
+			continue
+		}
+
+		backoff *= 2
+		if backoff > sv.MaxBackoff {
+			backoff = sv.MaxBackoff
+		}
+	}
+}
+
+// The listener is detached by the agent, so the process isn't tracked after the start.
+// Profiler variables are removed to not attach the profiler to the agent itself
+func (sv *Supervisor) startAgent() {
+	agent := exec.Command(sv.Config.Command, sv.Config.Args...)
+	agent.Env = withoutProfilerVariables(os.Environ())
+	agent.Stdout = os.Stdout
+	agent.Stderr = os.Stderr
+
+	if err := agent.Start(); err != nil {
+		sv.Log.Warning("Sealights. Failed to start background test listener: %v", err)
+		return
+	}
+
+	go agent.Wait()
+}
+
+// Ask the listener to flush footprints and wait for it up to the grace period,
+// so the listener isn't killed together with the container before it's done
+func (sv *Supervisor) flushAgent() {
+	gracePeriod := time.Duration(sv.Config.GracePeriodSeconds) * time.Second
+	if gracePeriod <= 0 || len(sv.Config.StopArgs) == 0 {
+		return
+	}
+
+	sv.Log.Info("Sealights. Shutdown requested, flushing background test listener (up to %s)", gracePeriod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+
+	agent := exec.CommandContext(ctx, sv.Config.Command, sv.Config.StopArgs...)
+	agent.Env = withoutProfilerVariables(os.Environ())
+	agent.Stdout = os.Stdout
+	agent.Stderr = os.Stderr
+
+	if err := agent.Run(); err != nil {
+		if ctx.Err() != nil {
+			sv.Log.Warning("Sealights. Background test listener isn't flushed in %s", gracePeriod)
+		} else {
+			sv.Log.Warning("Sealights. Failed to flush background test listener: %v", err)
+		}
+	}
+}
+
+func isShutdownSignal(sig os.Signal) bool {
+	for _, shutdownSignal := range shutdownSignals {
+		if sig == shutdownSignal {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (sv *Supervisor) waitReady() bool {
+	deadline := time.Now().Add(sv.ReadyTimeout)
+	for time.Now().Before(deadline) {
+		if sv.isReady() {
+			return true
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	return false
+}
+
+func (sv *Supervisor) isReady() bool {
+	connection, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", sv.Config.Port), time.Second)
+	if err != nil {
+		return false
+	}
+	connection.Close()
+
+	return true
+}
+
+func readSupervisorConfig() (*SupervisorConfig, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(executable), SupervisorConfigFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	var config SupervisorConfig
+	if err = json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// Install the buildpack executable as the supervisor binary and its configuration
+func installSupervisor(fileSystem FileSystem, agentDir string, config SupervisorConfig) error {
+	source, err := supervisorSource()
+	if err != nil {
+		return err
+	}
+
+	exists, err := fileExists(fileSystem, source)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrSupervisorNotFound
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	if err = fileSystem.WriteFile(filepath.Join(agentDir, SupervisorConfigFileName), data, 0600); err != nil {
+		return err
+	}
+
+	fh, err := fileSystem.Open(source)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	return writeFile(fileSystem, fh, filepath.Join(agentDir, supervisorFileName()), ExecutableFileMode)
+}
+
+// ParseShutdownGracePeriod accepts number of seconds or duration string ('10s', '1m')
+func ParseShutdownGracePeriod(value interface{}) (time.Duration, error) {
+	switch typed := value.(type) {
+	case nil:
+		return DefaultShutdownGracePeriod, nil
+	case float64:
+		if typed < 0 {
+			return DefaultShutdownGracePeriod, fmt.Errorf("negative value '%v'", typed)
+		}
+		return time.Duration(typed * float64(time.Second)), nil
+	case string:
+		typed = strings.TrimSpace(typed)
+		if typed == "" {
+			return DefaultShutdownGracePeriod, nil
+		}
+
+		if seconds, err := strconv.ParseFloat(typed, 64); err == nil {
+			return ParseShutdownGracePeriod(seconds)
+		}
+
+		duration, err := time.ParseDuration(typed)
+		if err != nil || duration < 0 {
+			return DefaultShutdownGracePeriod, fmt.Errorf("invalid duration '%s'", typed)
+		}
+		return duration, nil
+	default:
+		return DefaultShutdownGracePeriod, fmt.Errorf("unexpected value '%v'", value)
+	}
+}
+
+func isSupervisorAvailable(fileSystem FileSystem) bool {
+	source, err := supervisorSource()
+	if err != nil {
+		return false
+	}
+
+	exists, err := fileExists(fileSystem, source)
+	return err == nil && exists
+}
+
+func supervisorFileName() string {
+	if runtime.GOOS == "windows" {
+		return SupervisorBinaryName + ".exe"
+	}
+
+	return SupervisorBinaryName
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		return exitErr.ExitCode()
+	}
+
+	return 1
+}
@@ -0,0 +1,72 @@
+package sealights
+
+import (
+	"bytes"
+	"net"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+func TestSupervisorIsReady(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	supervisor := NewSupervisor(libbuildpack.NewLogger(&bytes.Buffer{}), SupervisorConfig{Port: port})
+	if !supervisor.isReady() {
+		t.Error("listener accepting connections is expected to be ready")
+	}
+
+	listener.Close()
+	if supervisor.isReady() {
+		t.Error("closed listener is not expected to be ready")
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("exit code is checked with the linux shell")
+	}
+
+	if code := exitCode(nil); code != 0 {
+		t.Errorf("expected 0 without an error, got %d", code)
+	}
+	if code := exitCode(exec.Command("sh", "-c", "exit 3").Run()); code != 3 {
+		t.Errorf("expected the exit code of the application, got %d", code)
+	}
+	if code := exitCode(exec.Command("/nonexistent/app").Run()); code != 1 {
+		t.Errorf("expected 1 when the application isn't started, got %d", code)
+	}
+}
+
+func TestParseShutdownGracePeriod(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected time.Duration
+	}{
+		{nil, DefaultShutdownGracePeriod},
+		{"", DefaultShutdownGracePeriod},
+		{float64(10), 10 * time.Second},
+		{"2.5", 2500 * time.Millisecond},
+		{" 1m ", time.Minute},
+		{float64(0), 0},
+	}
+	for _, test := range tests {
+		gracePeriod, err := ParseShutdownGracePeriod(test.value)
+		if err != nil || gracePeriod != test.expected {
+			t.Errorf("'%v': expected '%s', got '%s' (%v)", test.value, test.expected, gracePeriod, err)
+		}
+	}
+
+	for _, value := range []interface{}{float64(-1), "-5s", "soon", true} {
+		if gracePeriod, err := ParseShutdownGracePeriod(value); err == nil || gracePeriod != DefaultShutdownGracePeriod {
+			t.Errorf("'%v': expected the default grace period with an error, got '%s' (%v)", value, gracePeriod, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package sealights
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const (
+	// StagingPhaseFinalize installs the agent into the application directory and modifies the start command
+	StagingPhaseFinalize = "finalize"
+	// StagingPhaseSupply installs the agent into the dependency directory and uses profile.d script to attach to the application
+	StagingPhaseSupply = "supply"
+)
+
+const AgentDirEnvVariable = "SL_AGENT_DIR"
+
+var ErrUnsupportedInSupplyPhase = errors.New("option is not supported in the supply phase")
+
+// Supplier installs the agent using the standard supply buildpack layout:
+// agent is placed into the dependency directory, executable is linked into 'bin',
+// staging env variables are written into 'env' and the runtime configuration is done
+// by the 'profile.d' script, so it works with any final buildpack
+type Supplier struct {
+	Log                *libbuildpack.Logger
+	Options            *SealightsOptions
+	Stager             *libbuildpack.Stager
+	AgentDirAbsolute   string
+	AgentDirForRuntime string
+	FileSystem         FileSystem
+}
+
+// SupplyPlan describes changes of the dependency directory without applying them
+type SupplyPlan struct {
+	ProfileDScript  string
+	Variables       map[string]string
+	ListenerCommand string
+	SidecarCommand  string
+}
+
+func NewSupplier(log *libbuildpack.Logger, options *SealightsOptions, stager *libbuildpack.Stager) *Supplier {
+	return &Supplier{
+		Log:                log,
+		Options:            options,
+		Stager:             stager,
+		AgentDirAbsolute:   filepath.Join(stager.DepDir(), AgentDir),
+		AgentDirForRuntime: filepath.Join("${DEPS_DIR}", stager.DepsIdx(), AgentDir),
+		FileSystem:         OsFileSystem{},
+	}
+}
+
+// PlanSupply computes the profile.d script content. The start command can't be
+// changed from the supply phase, so only the background test listener and PIC modes are supported
+func (su *Supplier) PlanSupply() (*SupplyPlan, error) {
+	if su.Options.CustomCommand != "" {
+		return nil, fmt.Errorf("%w: 'customCommand'", ErrUnsupportedInSupplyPhase)
+	}
+
+	if su.Options.Verb != "" && su.Options.Verb != "startBackgroundTestListener" {
+		return nil, fmt.Errorf("%w: verb '%s'", ErrUnsupportedInSupplyPhase, su.Options.Verb)
+	}
+
+	if su.Options.UseSupervisor {
+		return nil, fmt.Errorf("%w: 'useSupervisor'", ErrUnsupportedInSupplyPhase)
+	}
+
+	envManager := NewEnvManager(su.Log, su.Options)
+
+	plan := &SupplyPlan{
+		ProfileDScript: su.profileDScriptName(),
+		Variables:      envManager.GetVariables(su.AgentDirForRuntime),
+	}
+	plan.Variables[StagingReportEnvVariable] = filepath.Join(su.AgentDirForRuntime, StagingReportFileName)
+
+	if su.Options.Verb == "startBackgroundTestListener" {
+		launcher := &Launcher{Log: su.Log, Options: su.Options, AgentDirForRuntime: su.AgentDirForRuntime, Stager: su.Stager, FileSystem: su.FileSystem}
+		if su.Options.UseSidecar {
+			plan.SidecarCommand = sidecarCommand(launcher.agentCommandLine())
+		} else {
+			plan.ListenerCommand = launcher.agentCommandLine()
+		}
+	}
+
+	return plan, nil
+}
+
+func (su *Supplier) ApplyPlan(plan *SupplyPlan, steps *StagingSteps) error {
+	_, err := steps.Run(StepWriteProfileD, func() error {
+		return su.Stager.WriteProfileD(plan.ProfileDScript, su.profileDScript(plan))
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = steps.Run(StepLinkAgentBinary, su.linkAgentBinary)
+	if err != nil {
+		return err
+	}
+
+	if plan.SidecarCommand != "" {
+		_, err = steps.Run(StepRegisterSidecar, func() error {
+			return writeSidecar(su.FileSystem, su.Stager.DepDir(), plan.SidecarCommand)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = steps.Run(StepWriteSupplyEnvDir, func() error {
+		return su.Stager.WriteEnvFile(AgentDirEnvVariable, su.AgentDirAbsolute)
+	})
+
+	return err
+}
+
+// Script starts the listener before the profiler variables are exported,
+// otherwise the profiler would be attached to the agent itself
+func (su *Supplier) profileDScript(plan *SupplyPlan) string {
+	envManager := NewEnvManager(su.Log, su.Options)
+
+	script := ""
+	if plan.ListenerCommand != "" {
+		script += listenerStartScript(plan.ListenerCommand)
+	}
+
+	return script + envManager.FormatVariables(plan.Variables)
+}
+
+// Failure of the listener doesn't block the application start
+func listenerStartScript(listenerCommand string) string {
+	if runtime.GOOS == "windows" {
+		return fmt.Sprintf("%s || echo Sealights. Failed to start background test listener\n", listenerCommand)
+	}
+
+	return fmt.Sprintf("%s || echo \"Sealights. Failed to start background test listener\" >&2\n", listenerCommand)
+}
+
+// Link agent executable into the 'bin' directory, so it is available in PATH
+// for the subsequent buildpacks and the application
+func (su *Supplier) linkAgentBinary() error {
+	agentName := agentExecutableName()
+
+	binDir := filepath.Join(su.Stager.DepDir(), "bin")
+	if err := su.FileSystem.MkdirAll(binDir, 0755); err != nil {
+		return err
+	}
+
+	linkPath := filepath.Join(binDir, agentName)
+	relativeTarget, err := filepath.Rel(binDir, filepath.Join(su.AgentDirAbsolute, agentName))
+	if err != nil {
+		return err
+	}
+
+	su.FileSystem.Remove(linkPath)
+	return su.FileSystem.Symlink(relativeTarget, linkPath)
+}
+
+func (su *Supplier) profileDScriptName() string {
+	if runtime.GOOS == "windows" {
+		return "sealights.bat"
+	} else {
+		return "sealights.sh"
+	}
+}
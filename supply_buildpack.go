@@ -0,0 +1,42 @@
+package sealights
+
+import (
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// RunSupplyBuildpack runs the Sealights integration as a standalone supply buildpack.
+// The buildpack is never the final one, so the agent is always installed in the supply phase
+func RunSupplyBuildpack(stager *libbuildpack.Stager, log *libbuildpack.Logger) error {
+	if err := stager.CheckBuildpackValid(); err != nil {
+		return err
+	}
+
+	hook := &SealightsHook{Log: log, Command: &libbuildpack.Command{}, FileSystem: OsFileSystem{}}
+
+	conf := hook.newConfiguration(stager, StagingPhaseSupply)
+	if conf.UseSealights() {
+		log.BeginStep("Sealights. Supplying agent")
+
+		var err error
+		if isDryRun() {
+			err = hook.printSupplyPlan(conf, stager)
+		} else {
+			err = hook.Supply(conf, stager)
+		}
+
+		if err != nil {
+			log.Error("Sealights. Failed to supply agent: %s", err.Error())
+			return err
+		}
+	} else {
+		log.Warning("Sealights service isn't configured, nothing to supply")
+	}
+
+	if err := stager.WriteConfigYml(nil); err != nil {
+		log.Error("Error writing config.yml: %s", err.Error())
+		return err
+	}
+
+	stager.StagingComplete()
+	return nil
+}
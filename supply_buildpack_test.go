@@ -0,0 +1,68 @@
+package sealights
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+func newTestSupplyStager(t *testing.T, log *libbuildpack.Logger) *libbuildpack.Stager {
+	// manifest and VERSION of the supply buildpack itself
+	manifest, err := libbuildpack.NewManifest(".", log, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	depsDir := t.TempDir()
+	if err = os.MkdirAll(filepath.Join(depsDir, "0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return libbuildpack.NewStager([]string{t.TempDir(), t.TempDir(), depsDir, "0"}, log, manifest)
+}
+
+func TestRunSupplyBuildpackWithoutService(t *testing.T) {
+	t.Setenv("VCAP_SERVICES", "{}")
+
+	output := &bytes.Buffer{}
+	log := libbuildpack.NewLogger(output)
+	stager := newTestSupplyStager(t, log)
+
+	if err := RunSupplyBuildpack(stager, log); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(output.String(), "nothing to supply") {
+		t.Errorf("warning is expected:\n%s", output.String())
+	}
+	if _, err := os.Stat(filepath.Join(stager.DepDir(), "config.yml")); err != nil {
+		t.Errorf("config.yml is expected: %v", err)
+	}
+}
+
+func TestRunSupplyBuildpackForcesSupplyPhase(t *testing.T) {
+	t.Setenv(DryRunEnvVariable, "true")
+	t.Setenv("VCAP_SERVICES", `{"user-provided":[{"name":"sealights","credentials":{
+		"token":"secret-token","customAgentUrl":"https://agents.example.com/agent.tar.gz","stagingPhase":"finalize"}}]}`)
+
+	output := &bytes.Buffer{}
+	log := libbuildpack.NewLogger(output)
+	stager := newTestSupplyStager(t, log)
+
+	if err := RunSupplyBuildpack(stager, log); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Agent would be installed into " + filepath.Join(stager.DepDir(), AgentDir)
+	if !strings.Contains(output.String(), expected) {
+		t.Errorf("supply plan is expected:\n%s", output.String())
+	}
+	if _, err := os.Stat(filepath.Join(stager.DepDir(), AgentDir)); !os.IsNotExist(err) {
+		t.Error("agent must not be installed in the dry run mode")
+	}
+}
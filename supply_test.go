@@ -0,0 +1,79 @@
+package sealights
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+func newTestSupplier(t *testing.T, options *SealightsOptions) *Supplier {
+	log := libbuildpack.NewLogger(&bytes.Buffer{})
+	depsDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(depsDir, "0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	stager := libbuildpack.NewStager([]string{t.TempDir(), t.TempDir(), depsDir, "0"}, log, nil)
+
+	return NewSupplier(log, options, stager)
+}
+
+func TestPlanSupplyUnsupportedOptions(t *testing.T) {
+	tests := []*SealightsOptions{
+		{Verb: "startBackgroundTestListener", CustomCommand: "./run.sh"},
+		{Verb: "startExecution"},
+	}
+
+	for _, options := range tests {
+		supplier := newTestSupplier(t, options)
+		if _, err := supplier.PlanSupply(); !errors.Is(err, ErrUnsupportedInSupplyPhase) {
+			t.Errorf("%+v: expected %v, got %v", options, ErrUnsupportedInSupplyPhase, err)
+		}
+	}
+}
+
+func TestSupplyStartsListenerBeforeProfiler(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("profile.d script is tested on linux")
+	}
+
+	supplier := newTestSupplier(t, &SealightsOptions{
+		Verb:          "startBackgroundTestListener",
+		SlArguments:   map[string]string{"tokenFile": "token.txt"},
+		SlEnvironment: map[string]string{},
+	})
+
+	plan, err := supplier.PlanSupply()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := NewStagingSteps(supplier.Log, FailurePolicyFail)
+	if err = supplier.ApplyPlan(plan, steps); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(supplier.Stager.DepDir(), "profile.d", plan.ProfileDScript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := string(content)
+	listenerAt := strings.Index(script, "startBackgroundTestListener --tokenFile token.txt")
+	profilerAt := strings.Index(script, "CORECLR_ENABLE_PROFILING")
+	if listenerAt < 0 || profilerAt < 0 || listenerAt > profilerAt {
+		t.Errorf("listener is expected to be started before the profiler is configured:\n%s", script)
+	}
+
+	link, err := os.Readlink(filepath.Join(supplier.Stager.DepDir(), "bin", LinuxAgentName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link != filepath.Join("..", AgentDir, LinuxAgentName) {
+		t.Errorf("unexpected agent link '%s'", link)
+	}
+}